@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/vyzo/libp2p-flare-test/events"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ReadEvents reads JSONL events; lines that are not events are skipped with a warning.
+func ReadEvents(r io.Reader, name string) ([]*events.Event, error) {
+	var result []*events.Event
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+
+		evt := new(events.Event)
+		if err := json.Unmarshal(data, evt); err != nil || evt.Type == "" {
+			warnf("%s:%d: skipping malformed event", name, line)
+			continue
+		}
+		if evt.Schema > events.SchemaVersion {
+			warnf("%s:%d: event schema version %d is newer than %d; some fields may be missing",
+				name, line, evt.Schema, events.SchemaVersion)
+		}
+		result = append(result, evt)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", name, err)
+	}
+
+	return result, nil
+}
+
+// announcement is the latest announced state of a peer in a domain at some point in time.
+type announcement struct {
+	time int64
+	*events.AnnounceEvt
+}
+
+type peerKey struct {
+	domain string
+	peer   peer.ID
+}
+
+// Announcements indexes the announcements of peers, so that connect attempts can be joined
+// with the state of the peers at the time of the attempt.
+type Announcements map[peerKey][]*announcement
+
+func NewAnnouncements(evts []*events.Event) Announcements {
+	result := make(Announcements)
+	for _, evt := range evts {
+		ann, ok := evt.Evt.(*events.AnnounceEvt)
+		if !ok {
+			continue
+		}
+
+		key := peerKey{domain: evt.Domain, peer: evt.Peer}
+		result[key] = append(result[key], &announcement{time: evt.Time, AnnounceEvt: ann})
+	}
+
+	for _, anns := range result {
+		sort.SliceStable(anns, func(i, j int) bool {
+			return anns[i].time < anns[j].time
+		})
+	}
+
+	return result
+}
+
+// Lookup returns the last announcement of a peer before the given time, or its first
+// announcement if it announced only later.
+func (a Announcements) Lookup(domain string, p peer.ID, t int64) *events.AnnounceEvt {
+	anns := a[peerKey{domain: domain, peer: p}]
+	if len(anns) == 0 {
+		return nil
+	}
+
+	i := sort.Search(len(anns), func(i int) bool {
+		return anns[i].time > t
+	})
+	if i == 0 {
+		return anns[0].AnnounceEvt
+	}
+
+	return anns[i-1].AnnounceEvt
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats are the report output formats.
+var Formats = map[string]func(w io.Writer, r *Report) error{
+	"md":   WriteMarkdown,
+	"csv":  WriteCSV,
+	"html": WriteHTML,
+}
+
+func WriteMarkdown(w io.Writer, r *Report) error {
+	fmt.Fprintf(w, "# Flare Report\n\n")
+	fmt.Fprintf(w, "Generated %s from %d events of %d peers.\n", r.Generated.Format(time.RFC3339), r.Events, r.Peers)
+
+	for _, t := range r.Tables {
+		fmt.Fprintf(w, "\n## %s\n\n", t.Title)
+		fmt.Fprintf(w, "| %s | Attempts | Successes | Success Rate |\n", t.Key)
+		fmt.Fprintf(w, "|---|---:|---:|---:|\n")
+		for _, row := range t.Rows() {
+			fmt.Fprintf(w, "| %s | %d | %d | %.1f%% |\n", row.Key, row.Attempts, row.Successes, 100*row.Rate())
+		}
+	}
+
+	return nil
+}
+
+func WriteCSV(w io.Writer, r *Report) error {
+	wr := csv.NewWriter(w)
+	wr.Write([]string{"table", "key", "attempts", "successes", "rate"})
+	for _, t := range r.Tables {
+		for _, row := range t.Rows() {
+			wr.Write([]string{
+				t.Title,
+				row.Key,
+				strconv.Itoa(row.Attempts),
+				strconv.Itoa(row.Successes),
+				strconv.FormatFloat(row.Rate(), 'f', 4, 64),
+			})
+		}
+	}
+
+	wr.Flush()
+	return wr.Error()
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(x float64) string {
+		return fmt.Sprintf("%.1f%%", 100*x)
+	},
+	"rfc3339": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flare Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; }
+td.num { text-align: right; }
+.bar { background: #4c9a2a; height: 0.8em; }
+</style>
+</head>
+<body>
+<h1>Flare Report</h1>
+<p>Generated {{rfc3339 .Generated}} from {{.Events}} events of {{.Peers}} peers.</p>
+{{range .Tables}}
+<h2>{{.Title}}</h2>
+<table>
+<tr><th>{{.Key}}</th><th>Attempts</th><th>Successes</th><th>Success Rate</th><th></th></tr>
+{{range .Rows}}<tr><td>{{.Key}}</td><td class="num">{{.Attempts}}</td><td class="num">{{.Successes}}</td><td class="num">{{percent .Rate}}</td><td style="width: 10em"><div class="bar" style="width: {{percent .Rate}}"></div></td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+func WriteHTML(w io.Writer, r *Report) error {
+	return htmlTemplate.Execute(w, r)
+}
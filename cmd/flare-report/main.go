@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/vyzo/libp2p-flare-test/events"
+	"github.com/vyzo/libp2p-flare-test/util"
+)
+
+const usage = `usage: %s [options] [events.jsonl ...]
+
+Builds a report of connection success rates from flarec event logs; the events are read
+from standard input if no files are given.
+
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	format := flag.String("format", "md", "report format: md, csv or html")
+	out := flag.String("out", "", "report output path; defaults to stdout")
+	minVersionStr := flag.String("minVersion", "", "minimum client version, e.g. 0.2; events of outdated, older or unversioned clients are excluded. Defaults to including all events")
+	flag.Parse()
+
+	var minVersion util.Version
+	if *minVersionStr != "" {
+		var err error
+		minVersion, err = util.ParseVersion(*minVersionStr)
+		if err != nil {
+			fatalf("%s", err)
+		}
+	}
+
+	write, ok := Formats[*format]
+	if !ok {
+		fatalf("unknown report format %s", *format)
+	}
+
+	var evts []*events.Event
+	if flag.NArg() == 0 {
+		result, err := ReadEvents(os.Stdin, "stdin")
+		if err != nil {
+			fatalf("%s", err)
+		}
+		evts = result
+	}
+
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fatalf("error opening %s: %s", path, err)
+		}
+
+		result, err := ReadEvents(f, path)
+		f.Close()
+		if err != nil {
+			fatalf("%s", err)
+		}
+		evts = append(evts, result...)
+	}
+
+	sort.SliceStable(evts, func(i, j int) bool {
+		return evts[i].Time < evts[j].Time
+	})
+
+	report := NewReport(evts, minVersion)
+	if report.Skipped > 0 {
+		warnf("skipped %d events of outdated clients (minimum version %s)", report.Skipped, minVersion)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fatalf("error creating %s: %s", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := write(bw, report); err != nil {
+		fatalf("error writing report: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		fatalf("error writing report: %s", err)
+	}
+}
+
+func warnf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vyzo/libp2p-flare-test/events"
+	"github.com/vyzo/libp2p-flare-test/util"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+)
+
+const unknown = "unknown"
+
+// Report is the summary of the success rates of connection attempts.
+type Report struct {
+	Generated time.Time
+	Events    int
+	Peers     int
+	// Skipped is the number of events of outdated clients that were skipped
+	Skipped int
+	Tables  []*Table
+}
+
+// Table is a breakdown of success rates by some key.
+type Table struct {
+	Title string
+	Key   string
+
+	rows map[string]*Row
+}
+
+type Row struct {
+	Key       string
+	Attempts  int
+	Successes int
+}
+
+func (r *Row) Rate() float64 {
+	if r.Attempts == 0 {
+		return 0
+	}
+	return float64(r.Successes) / float64(r.Attempts)
+}
+
+func newTable(title, key string) *Table {
+	return &Table{Title: title, Key: key, rows: make(map[string]*Row)}
+}
+
+func (t *Table) add(key string, success bool) {
+	if key == "" {
+		key = unknown
+	}
+
+	row, ok := t.rows[key]
+	if !ok {
+		row = &Row{Key: key}
+		t.rows[key] = row
+	}
+
+	row.Attempts++
+	if success {
+		row.Successes++
+	}
+}
+
+// Rows returns the rows of the table sorted by key.
+func (t *Table) Rows() []*Row {
+	result := make([]*Row, 0, len(t.rows))
+	for _, row := range t.rows {
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// NewReport builds a report from events; if there is a minimum version, events of outdated
+// clients are skipped. See isOutdated.
+func NewReport(evts []*events.Event, minVersion util.Version) *Report {
+	var filtered []*events.Event
+	peers := make(map[peer.ID]struct{})
+	skipped := 0
+	for _, evt := range evts {
+		if minVersion != nil && isOutdated(evt, minVersion) {
+			skipped++
+			continue
+		}
+		filtered = append(filtered, evt)
+		peers[evt.Peer] = struct{}{}
+	}
+
+	anns := NewAnnouncements(filtered)
+
+	byDomain := newTable("Success rate by domain", "Domain")
+	byNAT := newTable("Success rate by NAT type pair", "NAT Types")
+	byOS := newTable("Success rate by OS", "OS")
+	byVersion := newTable("Success rate by client version", "Version")
+	byNetwork := newTable("Success rate by network", "Network")
+	holePunch := newTable("Hole punching success rate by domain", "Domain")
+
+	for _, evt := range filtered {
+		switch e := evt.Evt.(type) {
+		case *events.ConnectEvt:
+			local := anns.Lookup(evt.Domain, evt.Peer, evt.Time)
+			remote := anns.Lookup(evt.Domain, e.RemotePeer, evt.Time)
+
+			var os string
+			if local != nil {
+				os = local.OSType
+			}
+
+			byDomain.add(evt.Domain, e.Success)
+			byNAT.add(natPair(local, remote), e.Success)
+			byOS.add(os, e.Success)
+			byVersion.add(evt.Version, e.Success)
+			byNetwork.add(e.Network, e.Success)
+
+		case *events.TraceEvt:
+			if end, ok := e.Evt.(*holepunch.EndHolePunchEvt); ok {
+				holePunch.add(evt.Domain, end.Success)
+			}
+		}
+	}
+
+	return &Report{
+		Generated: time.Now(),
+		Events:    len(filtered),
+		Peers:     len(peers),
+		Skipped:   skipped,
+		Tables:    []*Table{byDomain, byNAT, byOS, byVersion, byNetwork, holePunch},
+	}
+}
+
+// isOutdated returns true for events of clients below the minimum version. Clients tag their
+// events as outdated when the server tells them to upgrade, but legacy clients predate both the
+// tagging and versioned events, so unversioned events are also considered outdated.
+func isOutdated(evt *events.Event, minVersion util.Version) bool {
+	if evt.Outdated || evt.Schema == 0 || evt.Version == "" {
+		return true
+	}
+
+	v, err := util.ParseVersion(evt.Version)
+	if err != nil {
+		return true
+	}
+
+	return v.Less(minVersion)
+}
+
+// natPair returns the NAT types of the two sides of a connection attempt, in canonical order
+// as the outcome does not depend on which side initiated.
+func natPair(local, remote *events.AnnounceEvt) string {
+	a, b := unknown, unknown
+	if local != nil && local.NATType != "" {
+		a = local.NATType
+	}
+	if remote != nil && remote.NATType != "" {
+		b = remote.NATType
+	}
+	if b < a {
+		a, b = b, a
+	}
+	return a + " / " + b
+}
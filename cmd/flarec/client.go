@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
-	"math/rand"
 	"sync"
 	"time"
 
@@ -15,103 +15,83 @@ import (
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 
-	circuit "github.com/libp2p/go-libp2p-circuit/v2/client"
 	"github.com/libp2p/go-msgio/protoio"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
-var bootstrappersTCPString = []string{
-	"/ip4/147.75.83.83/tcp/4001/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
-	"/ip4/147.75.77.187/tcp/4001/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
-	"/ip4/147.75.94.115/tcp/4001/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
-	"/ip4/147.75.109.213/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
-	"/ip4/147.75.109.29/tcp/4001/p2p/QmZa1sAxajnQjVM8WjWXoMbmPd7NsWhfKsPkErzpm9wGkp",
-}
-
-var bootstrappersUDPString = []string{
-	"/ip4/147.75.83.83/udp/4001/quic/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
-	"/ip4/147.75.77.187/udp/4001/quic/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
-	"/ip4/147.75.94.115/udp/4001/quic/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
-	"/ip4/147.75.109.213/udp/4001/quic/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
-	"/ip4/147.75.109.29/udp/4001/quic/p2p/QmZa1sAxajnQjVM8WjWXoMbmPd7NsWhfKsPkErzpm9wGkp",
-}
-
-var bootstrappersTCP []*peer.AddrInfo
-var bootstrappersUDP []*peer.AddrInfo
-
-func init() {
-	for _, a := range bootstrappersTCPString {
-		pi, err := parseAddrInfo(a)
-		if err != nil {
-			panic(err)
-		}
-
-		bootstrappersTCP = append(bootstrappersTCP, pi)
-	}
-
-	for _, a := range bootstrappersUDPString {
-		pi, err := parseAddrInfo(a)
-		if err != nil {
-			panic(err)
-		}
-
-		bootstrappersUDP = append(bootstrappersUDP, pi)
-	}
-}
-
 type Client struct {
-	host   host.Host
-	tracer *Tracer
-	cfg    *Config
-	domain string
-	nick   string
-	server *peer.AddrInfo
-	relay  *peer.AddrInfo
+	host     host.Host
+	tracer   *Tracer
+	history  *History
+	selector *PeerSelector
+	monitor  *Monitor
+	lan      *LANPeers
+	domain   *Domain
+	nick     string
+
+	cfgMx sync.Mutex
+	cfg   *Config
+
+	relayMx      sync.Mutex
+	reservations map[peer.ID]*reservation
+	relayBackoff map[peer.ID]time.Time
+	relayDown    chan peer.ID
+	relayUpdate  chan struct{}
+
+	serverMx      sync.Mutex
+	announceMx    sync.Mutex
+	servers       []*serverState
+	announceAddrs []ma.Multiaddr
+	announcing    bool
+	natType       string
 }
 
 type ClientInfo struct {
-	Nick string
-	Info peer.AddrInfo
+	Nick         string
+	Info         peer.AddrInfo
+	SamePublicIP bool
+	NATType      string
+	// NetworkID is an opaque identifier of the public IP of the peer, set by the server
+	NetworkID string
 }
 
-func NewClient(h host.Host, tracer *Tracer, cfg *Config, domain, nick string) (*Client, error) {
-	var relay, server *peer.AddrInfo
-	var err error
-	if domain == "TCP" {
-		relay, err = parseAddrInfo(cfg.RelayAddrTCP)
-		if err != nil {
-			return nil, err
-		}
-
-		server, err = parseAddrInfo(cfg.ServerAddrTCP)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		relay, err = parseAddrInfo(cfg.RelayAddrUDP)
-		if err != nil {
-			return nil, err
-		}
+func NewClient(h host.Host, tracer *Tracer, history *History, cfg *Config, domain *Domain, nick string) (*Client, error) {
+	c := &Client{
+		host:         h,
+		tracer:       tracer,
+		history:      history,
+		selector:     NewPeerSelector(domain.Name, history),
+		monitor:      NewMonitor(h, tracer, cfg),
+		cfg:          cfg,
+		domain:       domain,
+		nick:         nick,
+		reservations: make(map[peer.ID]*reservation),
+		relayBackoff: make(map[peer.ID]time.Time),
+		relayDown:    make(chan peer.ID, 16),
+		relayUpdate:  make(chan struct{}, 1),
+	}
+	for _, server := range domain.Servers {
+		c.servers = append(c.servers, &serverState{info: server})
+	}
+	h.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: c.disconnected,
+	})
+
+	return c, nil
+}
 
-		server, err = parseAddrInfo(cfg.ServerAddrUDP)
-		if err != nil {
-			return nil, err
-		}
+func (c *Client) disconnected(_ network.Network, conn network.Conn) {
+	p := conn.RemotePeer()
+	if c.host.Network().Connectedness(p) == network.Connected {
+		return
 	}
 
-	return &Client{
-		host:   h,
-		tracer: tracer,
-		cfg:    cfg,
-		domain: domain,
-		nick:   nick,
-		relay:  relay,
-		server: server,
-	}, nil
+	c.relayDisconnected(p)
+	c.serverDisconnected(p)
 }
 
 func (c *Client) Domain() string {
-	return c.domain
+	return c.domain.Name
 }
 
 func (c *Client) ID() peer.ID {
@@ -122,8 +102,20 @@ func (c *Client) Addrs() []ma.Multiaddr {
 	return c.host.Addrs()
 }
 
+// DiscoverLANPeers enables mDNS discovery of peers in the local network, so that
+// connection attempts to them can be classified accordingly.
+func (c *Client) DiscoverLANPeers() error {
+	lan, err := NewLANPeers(c.host)
+	if err != nil {
+		return fmt.Errorf("error starting mDNS discovery: %w", err)
+	}
+
+	c.lan = lan
+	return nil
+}
+
 func (c *Client) ListPeers() ([]*ClientInfo, error) {
-	s, err := c.connectToServer()
+	s, err := c.openServerStream()
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to flare server: %w", err)
 	}
@@ -140,7 +132,7 @@ func (c *Client) getPeers(s network.Stream) ([]*ClientInfo, error) {
 	rd := protoio.NewDelimitedReader(s, 1<<20)
 
 	msg.Type = pb.FlareMessage_GETPEERS.Enum()
-	msg.GetPeers = &pb.GetPeers{Domain: &c.domain}
+	msg.GetPeers = &pb.GetPeers{Domain: &c.domain.Name}
 
 	if err := wr.WriteMsg(&msg); err != nil {
 		s.Reset()
@@ -176,35 +168,42 @@ func (c *Client) getPeers(s network.Stream) ([]*ClientInfo, error) {
 	return result, nil
 }
 
-func (c *Client) Connect(ci *ClientInfo) error {
-	// check for existing connections first
-	for _, conn := range c.host.Network().ConnsToPeer(ci.Info.ID) {
-		if !isRelayConn(conn) {
-			return nil
-		}
+func (c *Client) recordAttempt(ci *ClientInfo, relation string, err error) {
+	a := &Attempt{
+		Time:       time.Now(),
+		Domain:     c.domain.Name,
+		RemotePeer: ci.Info.ID,
+		RemoteNick: ci.Nick,
+		Network:    relation,
+		Success:    err == nil,
 	}
-
-	err := c.connectToBootstrappers()
 	if err != nil {
-		return fmt.Errorf("error connecting to bootstrappers: %w", err)
+		a.Error = err.Error()
 	}
 
-	// let identify get our observed addresses before starting
-	time.Sleep(time.Second)
-
-	err = c.connectToPeer(ci)
-	c.tracer.Connect(ci, err)
+	if err := c.history.Record(a); err != nil {
+		log.Warnf("error recording connection attempt: %s", err)
+	}
+}
 
-	return err
+func (c *Client) classifyNetwork(ci *ClientInfo) string {
+	switch {
+	case c.lan.IsLANPeer(ci.Info.ID):
+		return NetworkSameLAN
+	case ci.SamePublicIP:
+		return NetworkSamePublicIP
+	default:
+		return NetworkDifferent
+	}
 }
 
-func (c *Client) connectToPeer(ci *ClientInfo) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+func (c *Client) connectToPeer(ctx context.Context, ci *ClientInfo) error {
+	dialCtx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
-	err := c.host.Connect(ctx, ci.Info)
+	err := c.host.Connect(dialCtx, ci.Info)
 	if err != nil {
-		return fmt.Errorf("error establishing initial connection to peer: %w", err)
+		return fmt.Errorf("%w: %s", errInitialConnection, err)
 	}
 
 	deadline := time.After(time.Minute)
@@ -219,19 +218,24 @@ poll:
 		select {
 		case <-deadline:
 			break poll
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-time.After(time.Second):
 		}
 	}
 
-	return fmt.Errorf("no direct connection to peer")
+	return errNoDirectConnection
 }
 
 func (c *Client) connectToBootstrappers() error {
-	var pis []*peer.AddrInfo
-	if c.domain == "TCP" {
-		pis = bootstrappersTCP
-	} else {
-		pis = bootstrappersUDP
+	pis := c.domain.Bootstrappers
+	if len(pis) == 0 {
+		return fmt.Errorf("no bootstrappers configured for domain %s", c.domain.Name)
+	}
+
+	need := 4
+	if len(pis) < need {
+		need = len(pis)
 	}
 
 	count := 0
@@ -248,8 +252,8 @@ func (c *Client) connectToBootstrappers() error {
 		}
 	}
 
-	if count < 4 {
-		return fmt.Errorf("could not connect to enough bootstrappers -- need 4, got %d", count)
+	if count < need {
+		return fmt.Errorf("could not connect to enough bootstrappers -- need %d, got %d", need, count)
 	}
 
 	return nil
@@ -258,57 +262,81 @@ func (c *Client) connectToBootstrappers() error {
 func (c *Client) Background(wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	behavior := c.getNATBehavior()
+
 	natType, err := c.getNATType()
 	if err != nil {
-		log.Errorf("error determining NAT type: %s", err)
-		return
+		if behavior == nil {
+			log.Errorf("error determining NAT type: %s", err)
+			return
+		}
+
+		log.Warnf("error determining NAT type: %s; using NAT behavior classification", err)
+		natType = behavior.DeviceType()
 	}
 
-	log.Infof("%s NAT Device Type is %s", c.domain, natType)
-	c.tracer.Announce(natType.String())
+	log.Infof("%s NAT Device Type is %s", c.domain.Name, natType)
+	c.tracer.Announce(natType.String(), behavior)
+
+	c.serverMx.Lock()
+	c.natType = natType.String()
+	c.serverMx.Unlock()
 
 	if natType == network.NATDeviceTypeSymmetric {
-		log.Errorf("%s NAT type is impenetrable; sorry", c.domain)
+		log.Errorf("%s NAT type is impenetrable; sorry", c.domain.Name)
 		return
 	}
 
-	c.connectToRelay()
+	c.connectToRelays()
+
+	c.wait(c.config().Schedule.FirstRound())
 
-	sleep := 15*time.Minute + time.Duration(rand.Int63n(int64(30*time.Minute)))
-	log.Infof("waiting for %s...", sleep)
-	time.Sleep(sleep)
+	failures := 0
 	for {
+		schedule := c.config().Schedule
+
 		log.Infof("trying to connect to peers...")
 
 		peers, err := c.ListPeers()
 		if err != nil {
-			log.Warnf("error getting peers: %s", err)
-			time.Sleep(time.Minute)
+			failures++
+			retry := schedule.Retry(failures)
+			log.Warnf("error getting peers: %s; retrying in %s", err, retry)
+			c.wait(retry)
 			continue
 		}
+		failures = 0
 
-		log.Infof("got %d peers", len(peers))
-		for _, ci := range peers {
-			err = c.Connect(ci)
+		selected := c.selector.Select(c.config(), peers)
+		log.Infof("got %d peers; trying %d", len(peers), len(selected))
+		err = c.ConnectPeers(context.Background(), selected, func(ci *ClientInfo, _ time.Duration, err error) {
 			if err != nil {
 				log.Infof("error connecting to %s [%s]: %s", ci.Info.ID, ci.Nick, err)
 			} else {
 				log.Infof("successfully connected to %s [%s]", ci.Info.ID, ci.Nick)
 			}
+		})
+		if err != nil {
+			log.Warnf("error connecting to peers: %s", err)
 		}
 
-		if len(peers) > 25 {
-			sleep = 2*time.Hour + time.Duration(rand.Int63n(int64(4*time.Hour)))
-		} else if len(peers) > 10 {
-			sleep = time.Hour + time.Duration(rand.Int63n(int64(2*time.Hour)))
-		} else {
-			sleep = 30*time.Minute + time.Duration(rand.Int63n(int64(time.Hour)))
-		}
-		log.Infof("waiting for %s...", sleep)
-		time.Sleep(sleep)
+		c.wait(schedule.NextRound(len(peers)))
 	}
 }
 
+// wait waits for the given duration before the next round, extended to the end of the
+// quiet hours if it ends within them.
+func (c *Client) wait(d time.Duration) {
+	next := time.Now().Add(d)
+	if end := c.config().Schedule.QuietUntil(next); !end.IsZero() {
+		log.Infof("next round falls within quiet hours; waiting until %s", end.Format("15:04"))
+		next = end
+	}
+
+	log.Infof("waiting for %s...", time.Until(next).Round(time.Second))
+	time.Sleep(time.Until(next))
+}
+
 func (c *Client) getNATType() (network.NATDeviceType, error) {
 	sub, err := c.host.EventBus().Subscribe(new(event.EvtNATDeviceTypeChanged))
 	if err != nil {
@@ -325,15 +353,8 @@ func (c *Client) getNATType() (network.NATDeviceType, error) {
 		select {
 		case evt := <-sub.Out():
 			e := evt.(event.EvtNATDeviceTypeChanged)
-			switch c.domain {
-			case "TCP":
-				if e.TransportProtocol == network.NATTransportTCP {
-					return e.NatDeviceType, nil
-				}
-			case "UDP":
-				if e.TransportProtocol == network.NATTransportUDP {
-					return e.NatDeviceType, nil
-				}
+			if e.TransportProtocol == c.domain.Transport.NATTransport {
+				return e.NatDeviceType, nil
 			}
 		case <-time.After(time.Minute):
 			return 0, fmt.Errorf("timed out waiting for NAT type determination")
@@ -341,87 +362,32 @@ func (c *Client) getNATType() (network.NATDeviceType, error) {
 	}
 }
 
-func (c *Client) connectToRelay() {
-	// connect to relay and reserve slot
-	var rsvp *circuit.Reservation
-	var err error
-	for rsvp == nil {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-		err = c.host.Connect(ctx, *c.relay)
-		cancel()
-
-		if err != nil {
-			log.Warnf("error connecting to relay: %s; will retry in 1min", err)
-			time.Sleep(time.Minute)
-			continue
-		}
-
-		ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
-		rsvp, err = circuit.Reserve(ctx, c.host, *c.relay)
-		cancel()
-
-		if err != nil {
-			log.Warnf("error reserving slot in relay: %s; will retry in 1min", err)
-			time.Sleep(time.Minute)
-			continue
-		}
+func (c *Client) getNATBehavior() *NATBehavior {
+	if len(c.domain.EchoAddrs) == 0 {
+		return nil
 	}
 
-	c.host.ConnManager().Protect(c.relay.ID, "flare")
-
-	// announce our slot to the server
-	for {
-		s, err := c.connectToServer()
-		if err != nil {
-			log.Warnf("error connecting to server: %s; will retry in 1min", err)
-			time.Sleep(time.Minute)
-			continue
-		}
-
-		// announce presence
-		var msg pb.FlareMessage
-		wr := protoio.NewDelimitedWriter(s)
-
-		msg.Type = pb.FlareMessage_ANNOUNCE.Enum()
-		msg.Announce = &pb.Announce{
-			Domain:   &c.domain,
-			PeerInfo: makePeerInfo(c.nick, peer.AddrInfo{ID: c.host.ID(), Addrs: rsvp.Addrs}),
-		}
-
-		if err := wr.WriteMsg(&msg); err != nil {
-			log.Warnf("error announcing presence to server: %s; will retry in 1min", err)
-			s.Reset()
-			time.Sleep(time.Minute)
-			continue
-		}
-
-		s.Close()
-
-		c.host.ConnManager().Protect(c.server.ID, "flare")
-		break
+	behavior, err := probeNATBehavior(c.domain.EchoAddrs)
+	if err != nil {
+		log.Warnf("error classifying %s NAT behavior: %s", c.domain.Name, err)
+		return nil
 	}
 
-	// schedule refresh
-	go func() {
-		time.Sleep(30 * time.Minute)
-		err := c.connectToBootstrappers()
-		if err != nil {
-			log.Warnf("error connecting to bootstrappers: %s", err)
-		}
-		c.connectToRelay()
-	}()
+	log.Infof("%s NAT behavior: mapping is %s, filtering is %s, port preservation: %v",
+		c.domain.Name, behavior.Mapping, behavior.Filtering, behavior.PortPreservation)
+	return behavior
 }
 
-func (c *Client) connectToServer() (network.Stream, error) {
+func (c *Client) connectToServer(server *peer.AddrInfo) (network.Stream, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
-	err := c.host.Connect(ctx, *c.server)
+	err := c.host.Connect(ctx, *server)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to server: %w", err)
 	}
 
-	s, err := c.host.NewStream(ctx, c.server.ID, proto.ProtoID)
+	s, err := c.host.NewStream(ctx, server.ID, proto.ProtoID)
 	if err != nil {
 		return nil, fmt.Errorf("error opening stream to server: %W", err)
 	}
@@ -439,8 +405,10 @@ func (c *Client) connectToServer() (network.Stream, error) {
 		return nil, fmt.Errorf("error generating authen nonce: %w", err)
 	}
 
+	cfg := c.config()
 	msg.Type = pb.FlareMessage_AUTHEN.Enum()
-	msg.Authen = &pb.Authen{Nonce: nonce}
+	version := ClientVersion
+	msg.Authen = &pb.Authen{Nonce: nonce, ConfigVersion: &cfg.ConfigVersion, ClientVersion: &version}
 
 	if err := wr.WriteMsg(&msg); err != nil {
 		s.Reset()
@@ -468,17 +436,27 @@ func (c *Client) connectToServer() (network.Stream, error) {
 	serverProof := challenge.GetProof()
 	serverSalt := challenge.GetSalt()
 	serverNonce := challenge.GetNonce()
-	if !proto.Verify(c.cfg.Secret, serverSalt, nonce, serverProof) {
+	if !verifyServerProof(cfg.Secret, serverSalt, nonce, serverProof, challenge.GetProofs()) {
 		s.Reset()
 		return nil, fmt.Errorf("unexpected server response: authentication failure")
 	}
 
+	if upgrade := challenge.GetUpgrade(); upgrade != nil {
+		c.tracer.SetOutdated(true)
+		log.Warnf("client version %s is outdated; please upgrade to version %s or later. %s",
+			ClientVersion, upgrade.GetMinVersion(), upgrade.GetMessage())
+		if upgrade.GetRejected() {
+			s.Reset()
+			return nil, fmt.Errorf("server refused outdated client; minimum version is %s", upgrade.GetMinVersion())
+		}
+	}
+
 	salt, err := proto.Nonce()
 	if err != nil {
 		s.Reset()
 		return nil, fmt.Errorf("error generating authen salt: %w", err)
 	}
-	proof := proto.Proof(c.cfg.Secret, salt, serverNonce)
+	proof := proto.Proof(cfg.Secret, salt, serverNonce)
 
 	msg.Reset()
 	msg.Type = pb.FlareMessage_RESPONSE.Enum()
@@ -497,9 +475,31 @@ func (c *Client) connectToServer() (network.Stream, error) {
 	return s, nil
 }
 
+// verifyServerProof verifies the server's proof of knowledge of our secret; while the server is
+// rotating its secret, our secret may be one of its previous secrets, in which case it is proven
+// by one of the additional proofs.
+func verifyServerProof(secret string, salt, nonce, proof []byte, proofs [][]byte) bool {
+	if proto.Verify(secret, salt, nonce, proof) {
+		return true
+	}
+
+	for _, proof := range proofs {
+		if proto.Verify(secret, salt, nonce, proof) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func peerInfoToClientInfo(pi *pb.PeerInfo) (*ClientInfo, error) {
 	result := new(ClientInfo)
 	result.Nick = pi.GetNick()
+	result.SamePublicIP = pi.GetSamePublicIP()
+	result.NATType = pi.GetNatType()
+	if id := pi.GetNetworkID(); len(id) > 0 {
+		result.NetworkID = hex.EncodeToString(id)
+	}
 
 	pid, err := peer.IDFromBytes(pi.GetPeerID())
 	if err != nil {
@@ -518,9 +518,12 @@ func peerInfoToClientInfo(pi *pb.PeerInfo) (*ClientInfo, error) {
 	return result, nil
 }
 
-func makePeerInfo(nick string, pi peer.AddrInfo) *pb.PeerInfo {
+func makePeerInfo(nick, natType string, pi peer.AddrInfo) *pb.PeerInfo {
 	result := new(pb.PeerInfo)
 	result.Nick = &nick
+	if natType != "" {
+		result.NatType = &natType
+	}
 	result.PeerID = []byte(pi.ID)
 	for _, a := range pi.Addrs {
 		result.Addrs = append(result.Addrs, a.Bytes())
@@ -1,10 +1,175 @@
 package main
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vyzo/libp2p-flare-test/util"
+)
+
+// ConfigEnvPrefix is the prefix of environment variables overriding configuration fields.
+const ConfigEnvPrefix = "FLAREC"
+
 type Config struct {
-	Secret        string
+	// ConfigVersion is the version of the configuration; the presence servers push newer
+	// configurations to clients.
+	ConfigVersion uint64
+
+	Secret      string
+	LogzioToken string
+
+	// Domains are the test domains; if empty, the TCP and UDP domains are derived
+	// from the legacy per domain fields below.
+	Domains []*DomainConfig
+
 	ServerAddrTCP string
 	ServerAddrUDP string
 	RelayAddrTCP  string
 	RelayAddrUDP  string
-	LogzioToken   string
+
+	// EchoAddrsTCP and EchoAddrsUDP are the addresses of the flared address echo service,
+	// used for NAT behavior classification.
+	EchoAddrsTCP []string
+	EchoAddrsUDP []string
+
+	// MonitorPeriod is how long to keep direct connections alive after a successful
+	// hole punch; defaults to 1h.
+	MonitorPeriod util.Duration
+	// MonitorInterval is the interval between pings on monitored connections;
+	// defaults to 1m.
+	MonitorInterval util.Duration
+
+	// ConnLowWater and ConnHighWater are the connection manager watermarks: when the number of
+	// connections exceeds the high watermark, connections to the lowest scoring peers are closed
+	// until we are down to the low watermark; default to 32 and 64, or to the other watermark
+	// if only one is set and the default would be out of order.
+	ConnLowWater  int
+	ConnHighWater int
+	// RelayGracePeriod and DirectGracePeriod are the grace periods of new relayed and direct
+	// connections, during which they are not trimmed; default to 5m and 1h.
+	RelayGracePeriod  util.Duration
+	DirectGracePeriod util.Duration
+
+	// PeerSelection is the strategy for ordering the peers to try in each round: all, random or
+	// nat-diverse; defaults to random. Untested peers are always tried first.
+	PeerSelection string
+	// PeerCooldown is the minimum interval between attempts with the same peer; defaults to 6h,
+	// negative to disable.
+	PeerCooldown util.Duration
+	// MaxPeersPerRound caps the number of peers tried in each round; defaults to 25, negative
+	// for no cap.
+	MaxPeersPerRound int
+	// ConnectWorkers is the number of concurrent connection attempts; defaults to 4.
+	ConnectWorkers int
+
+	// Schedule is the schedule of the background test rounds.
+	Schedule *ScheduleConfig
+}
+
+// GetDomains returns the configuration of the test domains.
+func (cfg *Config) GetDomains() []*DomainConfig {
+	if len(cfg.Domains) > 0 {
+		return cfg.Domains
+	}
+
+	return []*DomainConfig{
+		{
+			Name:       "TCP",
+			Transport:  "tcp",
+			ServerAddr: cfg.ServerAddrTCP,
+			RelayAddr:  cfg.RelayAddrTCP,
+			EchoAddrs:  cfg.EchoAddrsTCP,
+			legacy:     true,
+		},
+		{
+			Name:       "UDP",
+			Transport:  "quic",
+			ServerAddr: cfg.ServerAddrUDP,
+			RelayAddr:  cfg.RelayAddrUDP,
+			EchoAddrs:  cfg.EchoAddrsUDP,
+			legacy:     true,
+		},
+	}
+}
+
+// Validate checks the configuration of the enabled domains and fills in defaults.
+func (cfg *Config) Validate(enabled func(name string) bool) error {
+	if cfg.Secret == "" {
+		return fmt.Errorf("Secret is required")
+	}
+
+	names := make(map[string]struct{})
+	for i, dc := range cfg.GetDomains() {
+		if dc.Name == "" {
+			return fmt.Errorf("Domains[%d].Name is required", i)
+		}
+		if _, dup := names[dc.Name]; dup {
+			return fmt.Errorf("Domains[%d]: duplicate domain %s", i, dc.Name)
+		}
+		names[dc.Name] = struct{}{}
+
+		if !enabled(dc.Name) {
+			continue
+		}
+
+		if err := dc.validate(i); err != nil {
+			return fmt.Errorf("domain %s: %w", dc.Name, err)
+		}
+	}
+
+	if cfg.ConnLowWater < 0 || cfg.ConnHighWater < 0 {
+		return fmt.Errorf("ConnLowWater and ConnHighWater must not be negative")
+	}
+
+	if cfg.MonitorPeriod == 0 {
+		cfg.MonitorPeriod = util.Duration(DefaultMonitorPeriod)
+	}
+	if cfg.MonitorInterval == 0 {
+		cfg.MonitorInterval = util.Duration(DefaultMonitorInterval)
+	}
+	if cfg.ConnHighWater != 0 && cfg.ConnLowWater != 0 && cfg.ConnHighWater < cfg.ConnLowWater {
+		return fmt.Errorf("ConnHighWater (%d) is lower than ConnLowWater (%d)", cfg.ConnHighWater, cfg.ConnLowWater)
+	}
+	cfg.ConnLowWater, cfg.ConnHighWater = connWatermarks(cfg.ConnLowWater, cfg.ConnHighWater)
+	if cfg.RelayGracePeriod == 0 {
+		cfg.RelayGracePeriod = util.Duration(DefaultRelayGracePeriod)
+	}
+	if cfg.DirectGracePeriod == 0 {
+		cfg.DirectGracePeriod = util.Duration(DefaultDirectGracePeriod)
+	}
+
+	if cfg.PeerSelection == "" {
+		cfg.PeerSelection = DefaultPeerSelection
+	}
+	if _, ok := SelectionStrategies[cfg.PeerSelection]; !ok {
+		known := make([]string, 0, len(SelectionStrategies))
+		for name := range SelectionStrategies {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("unknown PeerSelection %q; must be one of %s", cfg.PeerSelection, strings.Join(known, ", "))
+	}
+	if cfg.PeerCooldown == 0 {
+		cfg.PeerCooldown = util.Duration(DefaultPeerCooldown)
+	}
+	if cfg.MaxPeersPerRound == 0 {
+		cfg.MaxPeersPerRound = DefaultMaxPeersPerRound
+	}
+
+	if cfg.ConnectWorkers < 0 {
+		return fmt.Errorf("ConnectWorkers must not be negative")
+	}
+	if cfg.ConnectWorkers == 0 {
+		cfg.ConnectWorkers = DefaultConnectWorkers
+	}
+
+	if cfg.Schedule == nil {
+		cfg.Schedule = new(ScheduleConfig)
+	}
+	if err := cfg.Schedule.validate(); err != nil {
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	errInitialConnection  = errors.New("error establishing initial connection to peer")
+	errNoDirectConnection = errors.New("no direct connection to peer")
+	errBootstrap          = errors.New("error connecting to bootstrappers")
+)
+
+// Connection error classes
+const (
+	ErrorClassNone         = ""
+	ErrorClassServer       = "server"
+	ErrorClassBootstrap    = "bootstrap"
+	ErrorClassInitialConn  = "initial-connection"
+	ErrorClassNoDirectConn = "no-direct-connection"
+	ErrorClassTimeout      = "timeout"
+	ErrorClassOther        = "other"
+)
+
+const (
+	DefaultConnectWorkers = 4
+
+	// connectTimeout bounds each connection attempt: the initial connection through the relay
+	// and the wait for the hole punched direct connection.
+	connectTimeout = 2 * time.Minute
+)
+
+// attempts serializes connection attempts with the same remote network across all domains, as
+// hole punching with the same remote network from two hosts at once would interfere. Peers have
+// a distinct identity in each domain, so remote networks are identified by the network ID that
+// the server derives from their public IP; peers without one are only serialized by peer ID.
+var attempts = &attemptLocks{locks: make(map[string]chan struct{})}
+
+type attemptLocks struct {
+	mx    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// acquire waits until there is no other attempt in progress with the remote network of ci.
+func (a *attemptLocks) acquire(ctx context.Context, ci *ClientInfo) (release func(), err error) {
+	key := "peer/" + ci.Info.ID.Pretty()
+	if ci.NetworkID != "" {
+		key = "network/" + ci.NetworkID
+	}
+
+	for {
+		a.mx.Lock()
+		lock, busy := a.locks[key]
+		if !busy {
+			lock = make(chan struct{})
+			a.locks[key] = lock
+			a.mx.Unlock()
+
+			return func() {
+				a.mx.Lock()
+				delete(a.locks, key)
+				a.mx.Unlock()
+				close(lock)
+			}, nil
+		}
+		a.mx.Unlock()
+
+		select {
+		case <-lock:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Connect attempts to establish a direct connection to a peer through hole punching.
+func (c *Client) Connect(ctx context.Context, ci *ClientInfo) error {
+	if err := c.prepareConnect(); err != nil {
+		return err
+	}
+
+	return c.attempt(ctx, ci)
+}
+
+// ConnectPeers attempts to connect to peers concurrently, with up to ConnectWorkers attempts in
+// flight; done is called serially with the duration and result of each attempt.
+func (c *Client) ConnectPeers(ctx context.Context, peers []*ClientInfo, done func(ci *ClientInfo, elapsed time.Duration, err error)) error {
+	if len(peers) == 0 {
+		return nil
+	}
+
+	if err := c.prepareConnect(); err != nil {
+		return err
+	}
+
+	workers := c.config().ConnectWorkers
+	if workers <= 0 {
+		workers = DefaultConnectWorkers
+	}
+	if workers > len(peers) {
+		workers = len(peers)
+	}
+
+	work := make(chan *ClientInfo)
+	var doneMx sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ci := range work {
+				start := time.Now()
+				err := c.attempt(ctx, ci)
+				elapsed := time.Since(start)
+
+				doneMx.Lock()
+				done(ci, elapsed, err)
+				doneMx.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, ci := range peers {
+		select {
+		case work <- ci:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// prepareConnect connects to the bootstrappers before a round of connection attempts.
+func (c *Client) prepareConnect() error {
+	err := c.connectToBootstrappers()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBootstrap, err)
+	}
+
+	// let identify get our observed addresses before starting
+	time.Sleep(time.Second)
+
+	return nil
+}
+
+func (c *Client) attempt(ctx context.Context, ci *ClientInfo) error {
+	// check for existing connections first
+	for _, conn := range c.host.Network().ConnsToPeer(ci.Info.ID) {
+		if !isRelayConn(conn) {
+			return nil
+		}
+	}
+
+	release, err := attempts.acquire(ctx, ci)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	err = c.connectToPeer(ctx, ci)
+	relation := c.classifyNetwork(ci)
+	c.tracer.Connect(ci, relation, err)
+	c.recordAttempt(ci, relation, err)
+
+	if err == nil {
+		c.monitor.Watch(ci)
+	}
+
+	return err
+}
+
+// classifyConnectError returns the class of a connection error, for machine consumption.
+func classifyConnectError(err error) string {
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, errBootstrap):
+		return ErrorClassBootstrap
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, errInitialConnection):
+		return ErrorClassInitialConn
+	case errors.Is(err, errNoDirectConnection):
+		return ErrorClassNoDirectConn
+	default:
+		return ErrorClassOther
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const connectUsage = `usage: %s connect [options] <peerID|nick>
+
+Resolves a peer through the presence service and attempts to hole punch it once in each
+enabled domain where it is present, printing the hole punching trace. The exit status is 0
+if all attempts succeeded, 1 if any attempt failed and 2 if the peer was not found.
+
+`
+
+// Exit status of the connect command
+const (
+	connectOKStatus     = 0
+	connectFailedStatus = 1
+	// the peer was not found, or could not be resolved
+	connectErrorStatus = 2
+)
+
+// connectCommand implements the connect command with the constructed clients and returns
+// the exit status.
+func connectCommand(clients []*Client, target string) int {
+	// the target is either a peer ID or a nick
+	pid, _ := peer.Decode(target)
+
+	found := false
+	status := connectOKStatus
+	for _, c := range clients {
+		peers, err := c.ListPeers()
+		if err != nil {
+			fmt.Printf("%s: error retrieving peers: %s\n", c.Domain(), err)
+			status = connectErrorStatus
+			continue
+		}
+
+		var ci *ClientInfo
+		for _, p := range peers {
+			if (pid != "" && p.Info.ID == pid) || (pid == "" && p.Nick == target) {
+				ci = p
+				break
+			}
+		}
+		if ci == nil {
+			fmt.Printf("%s: peer %s not found\n", c.Domain(), target)
+			continue
+		}
+		found = true
+
+		fmt.Printf("%s: connecting to %s [%s] (%s NAT)\n", c.Domain(), ci.Info.ID, ci.Nick, natTypeString(ci.NATType))
+		for _, a := range ci.Info.Addrs {
+			fmt.Printf("\t%s\n", a)
+		}
+
+		start := time.Now()
+		err = c.Connect(context.Background(), ci)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			fmt.Printf("%s: FAILED after %s: %s\n", c.Domain(), elapsed, err)
+			if status == connectOKStatus {
+				status = connectFailedStatus
+			}
+			continue
+		}
+
+		fmt.Printf("%s: OK after %s\n", c.Domain(), elapsed)
+		for _, conn := range c.host.Network().ConnsToPeer(ci.Info.ID) {
+			if !isRelayConn(conn) {
+				fmt.Printf("\tdirect connection %s -> %s\n", conn.LocalMultiaddr(), conn.RemoteMultiaddr())
+			}
+		}
+	}
+
+	if !found && status == connectOKStatus {
+		return connectErrorStatus
+	}
+
+	return status
+}
+
+func natTypeString(natType string) string {
+	if natType == "" {
+		return "unknown"
+	}
+	return natType
+}
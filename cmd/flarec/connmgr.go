@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,42 +14,175 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 )
 
-var GracePeriod = 5 * time.Minute
+const (
+	DefaultConnLowWater      = 32
+	DefaultConnHighWater     = 64
+	DefaultRelayGracePeriod  = 5 * time.Minute
+	DefaultDirectGracePeriod = time.Hour
 
+	// trimInterval is the interval of the background house keeping, which is also the
+	// resolution of decaying tags.
+	trimInterval = time.Minute
+)
+
+// ConnManager is a connection manager that keeps the connections we care about and closes
+// the rest:
+//   - protected peers are never trimmed.
+//   - connections with open streams are never trimmed.
+//   - connections are never trimmed before their grace period has elapsed, which depends on
+//     whether the connection is relayed or direct.
+//   - idle connections to untagged peers are closed once their grace period has elapsed.
+//   - when the number of connections exceeds the high watermark, connections to the peers
+//     with the lowest tag scores are closed until we are down to the low watermark.
 type ConnManager struct {
 	sync.Mutex
 
 	ctx    context.Context
 	cancel func()
+	clock  Clock
+
+	lowWater          int
+	highWater         int
+	relayGracePeriod  time.Duration
+	directGracePeriod time.Duration
 
 	protected map[peer.ID]map[string]struct{}
-	conns     map[peer.ID]map[network.Conn]time.Time
+	peers     map[peer.ID]*peerInfo
+	decaying  map[string]*decayingTag
+}
+
+type peerInfo struct {
+	firstSeen time.Time
+	tags      map[string]int
+	decaying  map[*decayingTag]*connmgr.DecayingValue
+	conns     map[network.Conn]time.Time
+}
+
+// value is the score of the peer, which is the sum of all its tag values.
+func (pi *peerInfo) value() int {
+	value := 0
+	for _, v := range pi.tags {
+		value += v
+	}
+	for _, dv := range pi.decaying {
+		value += dv.Value
+	}
+	return value
 }
 
 var _ connmgr.ConnManager = (*ConnManager)(nil)
+var _ connmgr.Decayer = (*ConnManager)(nil)
 var _ network.Notifiee = (*ConnManager)(nil)
 
-func NewConnManager() *ConnManager {
+func NewConnManager(cfg *Config) *ConnManager {
+	return newConnManager(cfg, realClock{})
+}
+
+func newConnManager(cfg *Config, clock Clock) *ConnManager {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := &ConnManager{
-		ctx:       ctx,
-		cancel:    cancel,
-		protected: make(map[peer.ID]map[string]struct{}),
-		conns:     make(map[peer.ID]map[network.Conn]time.Time),
+		ctx:               ctx,
+		cancel:            cancel,
+		clock:             clock,
+		relayGracePeriod:  cfg.RelayGracePeriod.Or(DefaultRelayGracePeriod),
+		directGracePeriod: cfg.DirectGracePeriod.Or(DefaultDirectGracePeriod),
+		protected:         make(map[peer.ID]map[string]struct{}),
+		peers:             make(map[peer.ID]*peerInfo),
+		decaying:          make(map[string]*decayingTag),
 	}
 
-	go c.background()
+	c.lowWater, c.highWater = connWatermarks(cfg.ConnLowWater, cfg.ConnHighWater)
+
+	// the ticker is created here rather than in the background goroutine, so that it is
+	// anchored at construction time
+	go c.background(clock.NewTicker(trimInterval))
 
 	return c
 }
 
+// connWatermarks fills in the default watermarks; the default of an unset watermark is adjusted
+// to the other watermark when it would be out of order.
+func connWatermarks(low, high int) (int, int) {
+	switch {
+	case low <= 0 && high <= 0:
+		low, high = DefaultConnLowWater, DefaultConnHighWater
+	case low <= 0:
+		low = DefaultConnLowWater
+		if low > high {
+			low = high
+		}
+	case high <= 0:
+		high = DefaultConnHighWater
+		if high < low {
+			high = low
+		}
+	}
+
+	return low, high
+}
+
 // ConnectionManager interface
-func (c *ConnManager) TagPeer(peer.ID, string, int)                          {}
-func (c *ConnManager) UntagPeer(p peer.ID, tag string)                       {}
-func (c *ConnManager) UpsertTag(p peer.ID, tag string, upsert func(int) int) {}
-func (c *ConnManager) GetTagInfo(p peer.ID) *connmgr.TagInfo                 { return nil }
-func (c *ConnManager) TrimOpenConns(ctx context.Context)                     {}
-func (c *ConnManager) Notifee() network.Notifiee                             { return c }
+func (c *ConnManager) TagPeer(p peer.ID, tag string, value int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.getPeer(p).tags[tag] = value
+}
+
+func (c *ConnManager) UntagPeer(p peer.ID, tag string) {
+	c.Lock()
+	defer c.Unlock()
+
+	pi, ok := c.peers[p]
+	if !ok {
+		return
+	}
+
+	delete(pi.tags, tag)
+}
+
+func (c *ConnManager) UpsertTag(p peer.ID, tag string, upsert func(int) int) {
+	c.Lock()
+	defer c.Unlock()
+
+	pi := c.getPeer(p)
+	pi.tags[tag] = upsert(pi.tags[tag])
+}
+
+func (c *ConnManager) GetTagInfo(p peer.ID) *connmgr.TagInfo {
+	c.Lock()
+	defer c.Unlock()
+
+	pi, ok := c.peers[p]
+	if !ok {
+		return nil
+	}
+
+	info := &connmgr.TagInfo{
+		FirstSeen: pi.firstSeen,
+		Value:     pi.value(),
+		Tags:      make(map[string]int),
+		Conns:     make(map[string]time.Time),
+	}
+
+	for tag, v := range pi.tags {
+		info.Tags[tag] = v
+	}
+	for dt, dv := range pi.decaying {
+		info.Tags[dt.name] = dv.Value
+	}
+	for conn, opened := range pi.conns {
+		info.Conns[conn.RemoteMultiaddr().String()] = opened
+	}
+
+	return info
+}
+
+func (c *ConnManager) TrimOpenConns(ctx context.Context) {
+	c.trim(ctx, c.clock.Now())
+}
+
+func (c *ConnManager) Notifee() network.Notifiee { return c }
 
 func (c *ConnManager) Protect(id peer.ID, tag string) {
 	c.Lock()
@@ -101,6 +236,21 @@ func (c *ConnManager) Close() error {
 	return nil
 }
 
+// getPeer returns the peer info for p, creating it if it doesn't exist; the caller must hold the lock.
+func (c *ConnManager) getPeer(p peer.ID) *peerInfo {
+	pi, ok := c.peers[p]
+	if !ok {
+		pi = &peerInfo{
+			firstSeen: c.clock.Now(),
+			tags:      make(map[string]int),
+			decaying:  make(map[*decayingTag]*connmgr.DecayingValue),
+			conns:     make(map[network.Conn]time.Time),
+		}
+		c.peers[p] = pi
+	}
+	return pi
+}
+
 // Notifee interface
 func (c *ConnManager) Listen(network.Network, ma.Multiaddr)      {}
 func (c *ConnManager) ListenClose(network.Network, ma.Multiaddr) {}
@@ -109,13 +259,7 @@ func (c *ConnManager) Connected(_ network.Network, conn network.Conn) {
 	c.Lock()
 	defer c.Unlock()
 
-	p := conn.RemotePeer()
-	conns, ok := c.conns[p]
-	if !ok {
-		conns = make(map[network.Conn]time.Time)
-		c.conns[p] = conns
-	}
-	conns[conn] = time.Now()
+	c.getPeer(conn.RemotePeer()).conns[conn] = c.clock.Now()
 }
 
 func (c *ConnManager) Disconnected(_ network.Network, conn network.Conn) {
@@ -123,68 +267,285 @@ func (c *ConnManager) Disconnected(_ network.Network, conn network.Conn) {
 	defer c.Unlock()
 
 	p := conn.RemotePeer()
-	conns, ok := c.conns[p]
+	pi, ok := c.peers[p]
 	if !ok {
 		return
 	}
 
-	delete(conns, conn)
-	if len(conns) > 0 {
+	delete(pi.conns, conn)
+	if len(pi.conns) > 0 {
 		return
 	}
 
-	delete(c.conns, p)
+	delete(c.peers, p)
 }
 
 func (c *ConnManager) OpenedStream(_ network.Network, _ network.Stream) {}
 func (c *ConnManager) ClosedStream(_ network.Network, _ network.Stream) {}
 
 // internal house keeping
-func (c *ConnManager) background() {
-	ticker := time.NewTicker(time.Minute)
+func (c *ConnManager) background(ticker Ticker) {
 	defer ticker.Stop()
 
 	for {
 		select {
-		case now := <-ticker.C:
-			c.trim(now)
+		case now := <-ticker.C():
+			c.decay(now)
+			c.trim(c.ctx, now)
 		case <-c.ctx.Done():
 			return
 		}
 	}
 }
 
-func (c *ConnManager) trim(now time.Time) {
+func (c *ConnManager) gracePeriod(conn network.Conn) time.Duration {
+	if isRelayConn(conn) {
+		return c.relayGracePeriod
+	}
+	return c.directGracePeriod
+}
+
+// trimmable returns whether a connection can be closed.
+func (c *ConnManager) trimmable(conn network.Conn, opened, now time.Time) bool {
+	if len(conn.GetStreams()) > 0 {
+		return false
+	}
+
+	return now.Sub(opened) >= c.gracePeriod(conn)
+}
+
+// trim closes idle and excess connections; the pass stops early if ctx is canceled.
+func (c *ConnManager) trim(ctx context.Context, now time.Time) {
 	c.Lock()
 	defer c.Unlock()
 
-	for p, conns := range c.conns {
-		_, protected := c.protected[p]
-		if protected {
+	// close idle connections to untagged peers and forget tags of peers that never connected
+	for p, pi := range c.peers {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if len(pi.conns) == 0 {
+			if now.Sub(pi.firstSeen) >= c.relayGracePeriod {
+				delete(c.peers, p)
+			}
 			continue
 		}
 
-		for conn, opened := range conns {
-			if len(conn.GetStreams()) > 0 {
-				continue
+		if _, protected := c.protected[p]; protected {
+			continue
+		}
+
+		if pi.value() > 0 {
+			continue
+		}
+
+		for conn, opened := range pi.conns {
+			if c.trimmable(conn, opened, now) {
+				c.closeConn(p, pi, conn)
 			}
+		}
 
-			if now.Sub(opened) < GracePeriod {
-				continue
+		if len(pi.conns) == 0 {
+			delete(c.peers, p)
+		}
+	}
+
+	// trim down to the low watermark if we are above the high watermark
+	count := 0
+	for _, pi := range c.peers {
+		count += len(pi.conns)
+	}
+
+	if count <= c.highWater {
+		return
+	}
+
+	log.Debugf("connection count %d exceeds high watermark %d; trimming", count, c.highWater)
+
+	type candidate struct {
+		p     peer.ID
+		pi    *peerInfo
+		value int
+	}
+
+	var candidates []candidate
+	for p, pi := range c.peers {
+		if _, protected := c.protected[p]; protected {
+			continue
+		}
+		candidates = append(candidates, candidate{p: p, pi: pi, value: pi.value()})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].value == candidates[j].value {
+			return candidates[i].pi.firstSeen.Before(candidates[j].pi.firstSeen)
+		}
+		return candidates[i].value < candidates[j].value
+	})
+
+	for _, cand := range candidates {
+		if count <= c.lowWater || ctx.Err() != nil {
+			break
+		}
+
+		for conn, opened := range cand.pi.conns {
+			if count <= c.lowWater {
+				break
+			}
+
+			if c.trimmable(conn, opened, now) {
+				c.closeConn(cand.p, cand.pi, conn)
+				count--
 			}
+		}
+
+		if len(cand.pi.conns) == 0 {
+			delete(c.peers, cand.p)
+		}
+	}
+}
+
+// closeConn closes a connection; the caller must hold the lock.
+func (c *ConnManager) closeConn(p peer.ID, pi *peerInfo, conn network.Conn) {
+	log.Debugf("closing network connection to %s {%s}", p, conn)
+
+	err := conn.Close()
+	if err != nil {
+		log.Warnf("error closing connection to %s: %s", p, err)
+	}
+
+	delete(pi.conns, conn)
+}
+
+// Decayer interface
+func (c *ConnManager) RegisterDecayingTag(name string, interval time.Duration, decayFn connmgr.DecayFn, bumpFn connmgr.BumpFn) (connmgr.DecayingTag, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, exists := c.decaying[name]; exists {
+		return nil, fmt.Errorf("decaying tag %s already exists", name)
+	}
+
+	// round the interval up to our resolution
+	if rem := interval % trimInterval; rem != 0 || interval == 0 {
+		interval += trimInterval - rem
+	}
+
+	dt := &decayingTag{
+		cm:       c,
+		name:     name,
+		interval: interval,
+		decayFn:  decayFn,
+		bumpFn:   bumpFn,
+		next:     c.clock.Now().Add(interval),
+	}
+	c.decaying[name] = dt
+
+	return dt, nil
+}
+
+// decay applies the decay function of all decaying tags whose interval has elapsed.
+func (c *ConnManager) decay(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
 
-			log.Debugf("closing stale network connection to %s {%s}", p, conn)
+	for _, dt := range c.decaying {
+		if now.Before(dt.next) {
+			continue
+		}
+		dt.next = now.Add(dt.interval)
 
-			err := conn.Close()
-			if err != nil {
-				log.Warnf("error closing connection to %s: %s", p, err)
+		for _, pi := range c.peers {
+			dv, ok := pi.decaying[dt]
+			if !ok {
+				continue
 			}
 
-			delete(conns, conn)
+			after, rm := dt.decayFn(*dv)
+			if rm {
+				delete(pi.decaying, dt)
+				continue
+			}
+
+			dv.Value = after
+			dv.LastVisit = now
 		}
+	}
+}
+
+type decayingTag struct {
+	cm       *ConnManager
+	name     string
+	interval time.Duration
+	decayFn  connmgr.DecayFn
+	bumpFn   connmgr.BumpFn
+	next     time.Time
+	closed   bool
+}
 
-		if len(conns) == 0 {
-			delete(c.conns, p)
+var _ connmgr.DecayingTag = (*decayingTag)(nil)
+
+func (dt *decayingTag) Name() string            { return dt.name }
+func (dt *decayingTag) Interval() time.Duration { return dt.interval }
+
+func (dt *decayingTag) Bump(p peer.ID, delta int) error {
+	c := dt.cm
+	c.Lock()
+	defer c.Unlock()
+
+	if dt.closed {
+		return fmt.Errorf("decaying tag %s is closed", dt.name)
+	}
+
+	now := c.clock.Now()
+	pi := c.getPeer(p)
+	dv, ok := pi.decaying[dt]
+	if !ok {
+		dv = &connmgr.DecayingValue{
+			Tag:   dt,
+			Peer:  p,
+			Added: now,
 		}
+		pi.decaying[dt] = dv
+	}
+
+	dv.Value = dt.bumpFn(*dv, delta)
+	dv.LastVisit = now
+
+	return nil
+}
+
+func (dt *decayingTag) Remove(p peer.ID) error {
+	c := dt.cm
+	c.Lock()
+	defer c.Unlock()
+
+	if dt.closed {
+		return fmt.Errorf("decaying tag %s is closed", dt.name)
+	}
+
+	if pi, ok := c.peers[p]; ok {
+		delete(pi.decaying, dt)
 	}
+
+	return nil
+}
+
+func (dt *decayingTag) Close() error {
+	c := dt.cm
+	c.Lock()
+	defer c.Unlock()
+
+	if dt.closed {
+		return nil
+	}
+	dt.closed = true
+
+	delete(c.decaying, dt.name)
+	for _, pi := range c.peers {
+		delete(pi.decaying, dt)
+	}
+
+	return nil
 }
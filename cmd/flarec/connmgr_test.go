@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// mockClock is a manually advanced clock.
+type mockClock struct {
+	sync.Mutex
+
+	now     time.Time
+	tickers []*mockTicker
+}
+
+type mockTicker struct {
+	clock  *mockClock
+	period time.Duration
+	next   time.Time
+	ch     chan time.Time
+}
+
+func newMockClock() *mockClock {
+	return &mockClock{now: time.Unix(1600000000, 0)}
+}
+
+func (c *mockClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+func (c *mockClock) NewTicker(d time.Duration) Ticker {
+	c.Lock()
+	defer c.Unlock()
+
+	t := &mockTicker{clock: c, period: d, next: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Add advances the clock, firing any tickers that are due; like time.Ticker, ticks are
+// dropped if the receiver falls behind.
+func (c *mockClock) Add(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+func (t *mockTicker) C() <-chan time.Time { return t.ch }
+
+func (t *mockTicker) Stop() {
+	c := t.clock
+	c.Lock()
+	defer c.Unlock()
+
+	for i, other := range c.tickers {
+		if other == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+// mockNetwork delivers connection notifications to the connection manager the way the swarm
+// does: Connected synchronously when a connection is opened, Disconnected asynchronously
+// when it is closed.
+type mockNetwork struct {
+	network.Network
+
+	cm *ConnManager
+	wg sync.WaitGroup
+}
+
+func newMockNetwork(cm *ConnManager) *mockNetwork {
+	return &mockNetwork{cm: cm}
+}
+
+func (n *mockNetwork) connect(t *testing.T, relayed bool) *mockConn {
+	return n.connectPeer(test.RandPeerIDFatal(t), relayed)
+}
+
+func (n *mockNetwork) connectPeer(p peer.ID, relayed bool) *mockConn {
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	if relayed {
+		addr = ma.StringCast("/ip4/5.6.7.8/tcp/4001/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb/p2p-circuit")
+	}
+
+	conn := &mockConn{net: n, peer: p, addr: addr}
+	n.cm.Connected(n, conn)
+	return conn
+}
+
+// mockConn is a connection to a mock peer; only the methods used by the connection manager
+// are implemented.
+type mockConn struct {
+	network.Conn
+
+	net  *mockNetwork
+	peer peer.ID
+	addr ma.Multiaddr
+
+	mx      sync.Mutex
+	streams int
+	closed  bool
+}
+
+func (c *mockConn) RemotePeer() peer.ID           { return c.peer }
+func (c *mockConn) RemoteMultiaddr() ma.Multiaddr { return c.addr }
+
+func (c *mockConn) GetStreams() []network.Stream {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return make([]network.Stream, c.streams)
+}
+
+func (c *mockConn) setStreams(n int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.streams = n
+}
+
+func (c *mockConn) Close() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	c.net.wg.Add(1)
+	go func() {
+		defer c.net.wg.Done()
+		c.net.cm.Disconnected(c.net, c)
+	}()
+
+	return nil
+}
+
+func (c *mockConn) isClosed() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.closed
+}
+
+func newTestConnManager(t *testing.T, cfg *Config) (*ConnManager, *mockNetwork, *mockClock) {
+	clock := newMockClock()
+	cm := newConnManager(cfg, clock)
+	t.Cleanup(func() { cm.Close() })
+	return cm, newMockNetwork(cm), clock
+}
+
+func checkClosed(t *testing.T, conn *mockConn, expected bool) {
+	t.Helper()
+
+	if conn.isClosed() != expected {
+		t.Fatalf("expected connection to %s closed=%t", conn.peer, expected)
+	}
+}
+
+func TestConnManagerGracePeriod(t *testing.T) {
+	cm, net, clock := newTestConnManager(t, &Config{})
+
+	relayed := net.connect(t, true)
+	direct := net.connect(t, false)
+
+	clock.Add(DefaultRelayGracePeriod - time.Second)
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, relayed, false)
+	checkClosed(t, direct, false)
+
+	clock.Add(time.Second)
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, relayed, true)
+	checkClosed(t, direct, false)
+
+	clock.Add(DefaultDirectGracePeriod - DefaultRelayGracePeriod)
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, direct, true)
+}
+
+func TestConnManagerTrimCanceled(t *testing.T) {
+	cm, net, clock := newTestConnManager(t, &Config{})
+
+	conn := net.connect(t, true)
+	clock.Add(DefaultRelayGracePeriod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cm.TrimOpenConns(ctx)
+	checkClosed(t, conn, false)
+
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conn, true)
+}
+
+func TestConnManagerProtection(t *testing.T) {
+	cm, net, clock := newTestConnManager(t, &Config{})
+
+	conn := net.connect(t, true)
+	cm.Protect(conn.peer, "a")
+	cm.Protect(conn.peer, "b")
+
+	clock.Add(DefaultDirectGracePeriod)
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conn, false)
+
+	if !cm.Unprotect(conn.peer, "a") {
+		t.Fatal("expected peer to still be protected")
+	}
+	if !cm.IsProtected(conn.peer, "b") {
+		t.Fatal("expected peer to be protected for b")
+	}
+
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conn, false)
+
+	if cm.Unprotect(conn.peer, "b") {
+		t.Fatal("expected peer to be unprotected")
+	}
+
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conn, true)
+}
+
+func TestConnManagerStreams(t *testing.T) {
+	cm, net, clock := newTestConnManager(t, &Config{})
+
+	conn := net.connect(t, true)
+	conn.setStreams(1)
+
+	clock.Add(DefaultRelayGracePeriod)
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conn, false)
+
+	conn.setStreams(0)
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conn, true)
+}
+
+func TestConnManagerTags(t *testing.T) {
+	cm, net, clock := newTestConnManager(t, &Config{ConnLowWater: 2, ConnHighWater: 4})
+
+	var conns []*mockConn
+	for i := 1; i <= 5; i++ {
+		conn := net.connect(t, false)
+		cm.TagPeer(conn.peer, "test", i)
+		conns = append(conns, conn)
+	}
+
+	cm.UpsertTag(conns[0].peer, "test", func(v int) int { return v + 10 })
+	info := cm.GetTagInfo(conns[0].peer)
+	if info == nil || info.Value != 11 || info.Tags["test"] != 11 || len(info.Conns) != 1 {
+		t.Fatalf("unexpected tag info %+v", info)
+	}
+
+	// tagged peers are not trimmed when idle and within the watermarks, and nobody is
+	// trimmed within the grace period
+	clock.Add(DefaultDirectGracePeriod - time.Second)
+	cm.TrimOpenConns(context.Background())
+	for _, conn := range conns {
+		checkClosed(t, conn, false)
+	}
+
+	// above the high watermark, the lowest scoring peers are trimmed down to the low watermark
+	clock.Add(time.Second)
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conns[0], false)
+	checkClosed(t, conns[1], true)
+	checkClosed(t, conns[2], true)
+	checkClosed(t, conns[3], true)
+	checkClosed(t, conns[4], false)
+
+	// untagged peers are trimmed when idle
+	cm.UntagPeer(conns[4].peer, "test")
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conns[4], true)
+	checkClosed(t, conns[0], false)
+}
+
+func TestConnManagerDecay(t *testing.T) {
+	cm, net, clock := newTestConnManager(t, &Config{})
+
+	tag, err := cm.RegisterDecayingTag("decay", 90*time.Second, connmgr.DecayFixed(1), connmgr.BumpSumUnbounded())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Interval() != 2*time.Minute {
+		t.Fatalf("expected interval to be rounded up to 2m, got %s", tag.Interval())
+	}
+
+	_, err = cm.RegisterDecayingTag("decay", time.Minute, connmgr.DecayNone(), connmgr.BumpOverwrite())
+	if err == nil {
+		t.Fatal("expected error registering duplicate decaying tag")
+	}
+
+	conn := net.connect(t, true)
+	if err := tag.Bump(conn.peer, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	checkValue := func(expected int) {
+		t.Helper()
+		info := cm.GetTagInfo(conn.peer)
+		if info.Value != expected {
+			t.Fatalf("expected value %d, got %d", expected, info.Value)
+		}
+	}
+
+	checkValue(2)
+
+	clock.Add(tag.Interval())
+	cm.decay(clock.Now())
+	checkValue(1)
+
+	// decayed tags no longer keep the connection open
+	clock.Add(tag.Interval())
+	cm.decay(clock.Now())
+	checkValue(0)
+
+	clock.Add(DefaultRelayGracePeriod)
+	cm.TrimOpenConns(context.Background())
+	checkClosed(t, conn, true)
+
+	if err := tag.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tag.Bump(conn.peer, 1); err == nil {
+		t.Fatal("expected error bumping closed tag")
+	}
+}
+
+func TestConnManagerBackground(t *testing.T) {
+	cm, net, clock := newTestConnManager(t, &Config{})
+
+	conn := net.connect(t, true)
+
+	clock.Add(DefaultRelayGracePeriod)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !conn.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("connection was not trimmed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	net.wg.Wait()
+	if cm.GetTagInfo(conn.peer) != nil {
+		t.Fatal("expected peer to be forgotten after disconnection")
+	}
+}
+
+func TestConnManagerConcurrentNotifications(t *testing.T) {
+	cm, net, _ := newTestConnManager(t, &Config{})
+
+	p := test.RandPeerIDFatal(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				conn := net.connectPeer(p, j%2 == 0)
+				cm.GetTagInfo(p)
+				conn.Close()
+			}
+		}()
+	}
+
+	wg.Wait()
+	net.wg.Wait()
+
+	if cm.GetTagInfo(p) != nil {
+		t.Fatal("expected peer to be forgotten after all connections closed")
+	}
+
+	conn := net.connectPeer(p, false)
+	info := cm.GetTagInfo(p)
+	if info == nil || len(info.Conns) != 1 {
+		t.Fatalf("unexpected tag info %+v", info)
+	}
+
+	conn.Close()
+	net.wg.Wait()
+
+	if cm.GetTagInfo(p) != nil {
+		t.Fatal("expected peer to be forgotten after disconnection")
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vyzo/libp2p-flare-test/util"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	noise "github.com/libp2p/go-libp2p-noise"
+	quic "github.com/libp2p/go-libp2p-quic-transport"
+	tls "github.com/libp2p/go-libp2p-tls"
+	tcp "github.com/libp2p/go-tcp-transport"
+	websocket "github.com/libp2p/go-ws-transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Transport describes a libp2p transport that can be used in a test domain.
+type Transport struct {
+	// Constructor is the transport constructor passed to libp2p.Transport
+	Constructor interface{}
+	// ListenAddrs are the default IPv4 listen addresses
+	ListenAddrs []string
+	// ListenAddrs6 are the default IPv6 listen addresses
+	ListenAddrs6 []string
+	// Bootstrappers are the default IPv4 bootstrappers; IPv6 domains must configure their own.
+	Bootstrappers []string
+	// NATTransport is the transport protocol for NAT device type determination
+	NATTransport network.NATTransportProtocol
+}
+
+// Transports is the registry of known transports, keyed by name.
+//
+// The quic transport is QUIC draft-29 (/quic); QUIC-v1 and WebTransport need a newer go-libp2p
+// and are tracked separately.
+//
+// Transports without default Bootstrappers, like ws, require domains to configure their own.
+var Transports = map[string]*Transport{
+	"tcp": {
+		Constructor:  tcp.NewTCPTransport,
+		ListenAddrs:  []string{"/ip4/0.0.0.0/tcp/0"},
+		ListenAddrs6: []string{"/ip6/::/tcp/0"},
+		Bootstrappers: []string{
+			"/ip4/147.75.83.83/tcp/4001/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
+			"/ip4/147.75.77.187/tcp/4001/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+			"/ip4/147.75.94.115/tcp/4001/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+			"/ip4/147.75.109.213/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+			"/ip4/147.75.109.29/tcp/4001/p2p/QmZa1sAxajnQjVM8WjWXoMbmPd7NsWhfKsPkErzpm9wGkp",
+		},
+		NATTransport: network.NATTransportTCP,
+	},
+	"quic": {
+		Constructor:  quic.NewTransport,
+		ListenAddrs:  []string{"/ip4/0.0.0.0/udp/0/quic"},
+		ListenAddrs6: []string{"/ip6/::/udp/0/quic"},
+		Bootstrappers: []string{
+			"/ip4/147.75.83.83/udp/4001/quic/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
+			"/ip4/147.75.77.187/udp/4001/quic/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+			"/ip4/147.75.94.115/udp/4001/quic/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+			"/ip4/147.75.109.213/udp/4001/quic/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+			"/ip4/147.75.109.29/udp/4001/quic/p2p/QmZa1sAxajnQjVM8WjWXoMbmPd7NsWhfKsPkErzpm9wGkp",
+		},
+		NATTransport: network.NATTransportUDP,
+	},
+	"ws": {
+		Constructor:  websocket.New,
+		ListenAddrs:  []string{"/ip4/0.0.0.0/tcp/0/ws"},
+		ListenAddrs6: []string{"/ip6/::/tcp/0/ws"},
+		NATTransport: network.NATTransportTCP,
+	},
+}
+
+// DomainConfig is the configuration of a test domain.
+type DomainConfig struct {
+	// Name is the domain name, as announced to the presence server
+	Name string
+	// Transport is the name of the transport, as registered in Transports
+	Transport string
+	// IPVersion is the IP version of the domain, 4 or 6; defaults to 4.
+	// Domains are single stack, so dual stack testing is done with a domain per IP version.
+	IPVersion int
+	// Identity is the identity key file path; defaults to identity-<name>
+	Identity string
+	// ListenAddrs overrides the default listen addresses of the transport
+	ListenAddrs []string
+	// Bootstrappers overrides the default bootstrappers of the transport
+	Bootstrappers []string
+	// RelayAddrs are the candidate relays; RelayAddr is merged in for backwards compatibility
+	RelayAddrs []string
+	RelayAddr  string
+	// RelayCount is the number of relays to simultaneously hold reservations with;
+	// defaults to 2 or the number of relays if there are fewer.
+	RelayCount int
+	// ServerAddrs are the presence servers, in order of preference; ServerAddr is merged in
+	// for backwards compatibility
+	ServerAddrs []string
+	ServerAddr  string
+	EchoAddrs   []string
+
+	// legacy is set for the domains derived from the legacy per domain fields in Config
+	legacy bool
+}
+
+// validate checks the domain configuration; i is the index of the domain in the configuration.
+func (dc *DomainConfig) validate(i int) error {
+	// name the offending field as it appears in the configuration
+	field := func(name string, j int) string {
+		if dc.legacy {
+			name += dc.Name
+		} else {
+			name = fmt.Sprintf("Domains[%d].%s", i, name)
+		}
+		if j >= 0 {
+			name = fmt.Sprintf("%s[%d]", name, j)
+		}
+		return name
+	}
+
+	t, ok := Transports[dc.Transport]
+	if !ok {
+		known := make([]string, 0, len(Transports))
+		for name := range Transports {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("%s: unknown transport %q; must be one of %s", field("Transport", -1), dc.Transport, strings.Join(known, ", "))
+	}
+
+	if dc.IPVersion != 0 && dc.IPVersion != 4 && dc.IPVersion != 6 {
+		return fmt.Errorf("%s: must be 4 or 6", field("IPVersion", -1))
+	}
+
+	for j, a := range dc.ListenAddrs {
+		if err := util.CheckAddr(field("ListenAddrs", j), a, false); err != nil {
+			return err
+		}
+	}
+
+	if len(dc.Bootstrappers) == 0 && (dc.IPVersion == 6 || len(t.Bootstrappers) == 0) {
+		return fmt.Errorf("%s is required for %s domains over %s", field("Bootstrappers", -1), ipVersionName(dc.IPVersion), dc.Transport)
+	}
+	for j, a := range dc.Bootstrappers {
+		if err := util.CheckAddr(field("Bootstrappers", j), a, true); err != nil {
+			return err
+		}
+	}
+
+	if dc.RelayAddr == "" && len(dc.RelayAddrs) == 0 {
+		if dc.legacy {
+			return fmt.Errorf("%s is required", field("RelayAddr", -1))
+		}
+		return fmt.Errorf("%s is required", field("RelayAddrs", -1))
+	}
+	if dc.RelayAddr != "" {
+		if err := util.CheckAddr(field("RelayAddr", -1), dc.RelayAddr, true); err != nil {
+			return err
+		}
+	}
+	for j, a := range dc.RelayAddrs {
+		if err := util.CheckAddr(field("RelayAddrs", j), a, true); err != nil {
+			return err
+		}
+	}
+	if dc.RelayCount < 0 {
+		return fmt.Errorf("%s must not be negative", field("RelayCount", -1))
+	}
+
+	if dc.ServerAddr == "" && len(dc.ServerAddrs) == 0 {
+		if dc.legacy {
+			return fmt.Errorf("%s is required", field("ServerAddr", -1))
+		}
+		return fmt.Errorf("%s is required", field("ServerAddrs", -1))
+	}
+	if dc.ServerAddr != "" {
+		if err := util.CheckAddr(field("ServerAddr", -1), dc.ServerAddr, true); err != nil {
+			return err
+		}
+	}
+	for j, a := range dc.ServerAddrs {
+		if err := util.CheckAddr(field("ServerAddrs", j), a, true); err != nil {
+			return err
+		}
+	}
+
+	for j, a := range dc.EchoAddrs {
+		if err := util.CheckAddr(field("EchoAddrs", j), a, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ipVersionName(v int) string {
+	if v == 6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// Domain is a test domain: a transport with its own host, bootstrappers, relay and presence server.
+type Domain struct {
+	Name          string
+	IPVersion     int
+	Identity      string
+	Transport     *Transport
+	ListenAddrs   []string
+	Bootstrappers []*peer.AddrInfo
+	Relays        []*peer.AddrInfo
+	RelayCount    int
+	Servers       []*peer.AddrInfo
+	EchoAddrs     []ma.Multiaddr
+}
+
+func NewDomain(dc *DomainConfig) (*Domain, error) {
+	t, ok := Transports[dc.Transport]
+	if !ok {
+		return nil, fmt.Errorf("domain %s: unknown transport %s", dc.Name, dc.Transport)
+	}
+
+	d := &Domain{
+		Name:        dc.Name,
+		IPVersion:   dc.IPVersion,
+		Identity:    dc.Identity,
+		Transport:   t,
+		ListenAddrs: dc.ListenAddrs,
+	}
+
+	if d.IPVersion == 0 {
+		d.IPVersion = 4
+	}
+	if d.IPVersion != 4 && d.IPVersion != 6 {
+		return nil, fmt.Errorf("domain %s: bad IP version %d", d.Name, d.IPVersion)
+	}
+
+	if d.Identity == "" {
+		d.Identity = "identity-" + strings.ToLower(d.Name)
+	}
+
+	if len(d.ListenAddrs) == 0 {
+		if d.IPVersion == 6 {
+			d.ListenAddrs = t.ListenAddrs6
+		} else {
+			d.ListenAddrs = t.ListenAddrs
+		}
+	}
+
+	bootstrappers := dc.Bootstrappers
+	if len(bootstrappers) == 0 && d.IPVersion == 4 {
+		bootstrappers = t.Bootstrappers
+	}
+	if len(bootstrappers) == 0 {
+		return nil, fmt.Errorf("domain %s: no bootstrappers", d.Name)
+	}
+	for _, a := range bootstrappers {
+		pi, err := parseAddrInfo(a)
+		if err != nil {
+			return nil, fmt.Errorf("domain %s: error parsing bootstrapper address: %w", d.Name, err)
+		}
+		d.Bootstrappers = append(d.Bootstrappers, pi)
+	}
+
+	relays := dc.RelayAddrs
+	if dc.RelayAddr != "" {
+		relays = append([]string{dc.RelayAddr}, relays...)
+	}
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("domain %s: no relays", d.Name)
+	}
+	for _, a := range relays {
+		pi, err := parseAddrInfo(a)
+		if err != nil {
+			return nil, fmt.Errorf("domain %s: error parsing relay address: %w", d.Name, err)
+		}
+		d.Relays = append(d.Relays, pi)
+	}
+
+	d.RelayCount = dc.RelayCount
+	if d.RelayCount <= 0 {
+		d.RelayCount = 2
+	}
+	if d.RelayCount > len(d.Relays) {
+		d.RelayCount = len(d.Relays)
+	}
+
+	servers := dc.ServerAddrs
+	if dc.ServerAddr != "" {
+		servers = append([]string{dc.ServerAddr}, servers...)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("domain %s: no servers", d.Name)
+	}
+	for _, a := range servers {
+		pi, err := parseAddrInfo(a)
+		if err != nil {
+			return nil, fmt.Errorf("domain %s: error parsing server address: %w", d.Name, err)
+		}
+		d.Servers = append(d.Servers, pi)
+	}
+
+	for _, s := range dc.EchoAddrs {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("domain %s: error parsing echo address: %w", d.Name, err)
+		}
+		d.EchoAddrs = append(d.EchoAddrs, a)
+	}
+
+	return d, nil
+}
+
+// NewHost constructs the libp2p host for the domain.
+func (d *Domain) NewHost(privk crypto.PrivKey, tracer *Tracer, cm *ConnManager) (host.Host, error) {
+	var opts []libp2p.Option
+	opts = append(opts,
+		libp2p.Identity(privk),
+		libp2p.NoTransports,
+		libp2p.Security(noise.ID, noise.New),
+		libp2p.Security(tls.ID, tls.New),
+		libp2p.Transport(d.Transport.Constructor),
+		libp2p.ListenAddrStrings(d.ListenAddrs...),
+		libp2p.ConnectionManager(cm),
+		libp2p.EnableRelay(),
+		libp2p.EnableHolePunching(holepunch.WithTracer(tracer)),
+		libp2p.ForceReachabilityPrivate(),
+	)
+
+	return libp2p.New(context.Background(), opts...)
+}
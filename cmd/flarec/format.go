@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+func checkFormat(format string) error {
+	switch format {
+	case FormatTable, FormatJSON, FormatCSV:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %s; must be table, json or csv", format)
+	}
+}
+
+// writeOutput writes records in the given format; json output is the encoding of v, while
+// table and csv output consist of the header and the rows.
+func writeOutput(w io.Writer, format string, v interface{}, header []string, rows [][]string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case FormatCSV:
+		wr := csv.NewWriter(w)
+		wr.Write(header)
+		wr.WriteAll(rows)
+		return wr.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lderrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// DefaultHistoryPath is the default path of the local results history database.
+const DefaultHistoryPath = "history.db"
+
+const historyPrefix = "attempt/"
+
+// Attempt is the result of a connection attempt, as recorded in the history.
+type Attempt struct {
+	Time       time.Time
+	Domain     string
+	RemotePeer peer.ID
+	RemoteNick string
+	Network    string
+	Success    bool
+	Error      string `json:",omitempty"`
+}
+
+// History is the local database of connection attempts, keyed by domain and remote peer.
+//
+// The database is only opened for the duration of each operation, as leveldb allows a single
+// process to open it; this lets the history command read it while flarec is running.
+type History struct {
+	mx   sync.Mutex
+	path string
+}
+
+func NewHistory(path string) *History {
+	return &History{path: path}
+}
+
+// Record records a connection attempt; a nil history records nothing.
+func (h *History) Record(a *Attempt) error {
+	if h == nil {
+		return nil
+	}
+
+	value, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	db, err := h.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Put(historyKey(a.Domain, a.RemotePeer, a.Time), value, nil)
+}
+
+// Attempts returns the recorded attempts, in key order; if domain or p are not empty, only
+// attempts in that domain or with that peer are returned.
+func (h *History) Attempts(domain string, p peer.ID) ([]*Attempt, error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	db, err := h.open(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	prefix := historyPrefix
+	if domain != "" {
+		prefix += domain + "/"
+		if p != "" {
+			prefix += p.Pretty() + "/"
+		}
+	}
+
+	var result []*Attempt
+	iter := db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		a := new(Attempt)
+		if err := json.Unmarshal(iter.Value(), a); err != nil {
+			log.Warnf("skipping malformed history record %s: %s", iter.Key(), err)
+			continue
+		}
+
+		if p != "" && a.RemotePeer != p {
+			continue
+		}
+
+		result = append(result, a)
+	}
+
+	return result, iter.Error()
+}
+
+func (h *History) open(create bool) (*leveldb.DB, error) {
+	var (
+		db  *leveldb.DB
+		err error
+	)
+
+	// flarec may be writing the history concurrently; retry briefly while it holds the lock
+	for i := 0; i < 10; i++ {
+		db, err = leveldb.OpenFile(h.path, &opt.Options{ErrorIfMissing: !create})
+		if err == nil {
+			return db, nil
+		}
+		if os.IsNotExist(err) || lderrors.IsCorrupted(err) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return nil, fmt.Errorf("error opening history database %s: %w", h.path, err)
+}
+
+func historyKey(domain string, p peer.ID, t time.Time) []byte {
+	return []byte(fmt.Sprintf("%s%s/%s/%016x", historyPrefix, domain, p.Pretty(), t.UnixNano()))
+}
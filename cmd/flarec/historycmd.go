@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const historyUsage = `usage: %s history [options]
+
+Lists the recorded connection attempts, or with -peers the success ratio and last result
+for each peer.
+
+`
+
+// PeerSummary is the summary of the connection attempts with a peer in a domain.
+type PeerSummary struct {
+	Domain      string
+	RemotePeer  peer.ID
+	RemoteNick  string
+	Attempts    int
+	Successes   int
+	Ratio       float64
+	LastAttempt time.Time
+	LastSuccess bool
+	LastError   string `json:",omitempty"`
+}
+
+// HistoryCommand implements the history subcommand.
+func HistoryCommand(prog string, args []string) error {
+	fs := flag.NewFlagSet(prog+" history", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, historyUsage, prog)
+		fs.PrintDefaults()
+	}
+	dbPath := fs.String("history", DefaultHistoryPath, "history database path")
+	domain := fs.String("domain", "", "only list attempts in this domain")
+	remote := fs.String("peer", "", "only list attempts with this peer, given by peer ID or nick")
+	peers := fs.Bool("peers", false, "list per peer success ratios and last results instead of attempts")
+	format := fs.String("format", FormatTable, "output format: table, json or csv")
+	fs.Parse(args)
+
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	// the peer filter matches either the peer ID or the nick
+	var pid peer.ID
+	var nick string
+	if *remote != "" {
+		if p, err := peer.Decode(*remote); err == nil {
+			pid = p
+		} else {
+			nick = *remote
+		}
+	}
+
+	attempts, err := NewHistory(*dbPath).Attempts(*domain, pid)
+	if err != nil {
+		return err
+	}
+
+	if nick != "" {
+		var filtered []*Attempt
+		for _, a := range attempts {
+			if a.RemoteNick == nick {
+				filtered = append(filtered, a)
+			}
+		}
+		attempts = filtered
+	}
+
+	sort.SliceStable(attempts, func(i, j int) bool {
+		return attempts[i].Time.Before(attempts[j].Time)
+	})
+
+	if *peers {
+		return writePeerSummaries(*format, summarizeAttempts(attempts))
+	}
+
+	return writeAttempts(*format, attempts)
+}
+
+// summarizeAttempts summarizes attempts, which must be sorted by time, by domain and peer.
+func summarizeAttempts(attempts []*Attempt) []*PeerSummary {
+	type key struct {
+		domain string
+		peer   peer.ID
+	}
+
+	index := make(map[key]*PeerSummary)
+	var result []*PeerSummary
+	for _, a := range attempts {
+		k := key{domain: a.Domain, peer: a.RemotePeer}
+		s, ok := index[k]
+		if !ok {
+			s = &PeerSummary{Domain: a.Domain, RemotePeer: a.RemotePeer}
+			index[k] = s
+			result = append(result, s)
+		}
+
+		s.Attempts++
+		if a.Success {
+			s.Successes++
+		}
+		if a.RemoteNick != "" {
+			s.RemoteNick = a.RemoteNick
+		}
+		s.LastAttempt = a.Time
+		s.LastSuccess = a.Success
+		s.LastError = a.Error
+	}
+
+	for _, s := range result {
+		s.Ratio = float64(s.Successes) / float64(s.Attempts)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Domain != result[j].Domain {
+			return result[i].Domain < result[j].Domain
+		}
+		return result[i].LastAttempt.After(result[j].LastAttempt)
+	})
+
+	return result
+}
+
+func writeAttempts(format string, attempts []*Attempt) error {
+	header := []string{"Time", "Domain", "Peer", "Nick", "Network", "Result", "Error"}
+	rows := make([][]string, 0, len(attempts))
+	for _, a := range attempts {
+		rows = append(rows, []string{
+			a.Time.Format(time.RFC3339),
+			a.Domain,
+			a.RemotePeer.Pretty(),
+			a.RemoteNick,
+			a.Network,
+			resultString(a.Success),
+			a.Error,
+		})
+	}
+
+	if attempts == nil {
+		attempts = []*Attempt{}
+	}
+
+	return writeOutput(os.Stdout, format, attempts, header, rows)
+}
+
+func writePeerSummaries(format string, summaries []*PeerSummary) error {
+	header := []string{"Domain", "Peer", "Nick", "Attempts", "Successes", "Ratio", "Last Attempt", "Last Result", "Last Error"}
+	rows := make([][]string, 0, len(summaries))
+	for _, s := range summaries {
+		rows = append(rows, []string{
+			s.Domain,
+			s.RemotePeer.Pretty(),
+			s.RemoteNick,
+			strconv.Itoa(s.Attempts),
+			strconv.Itoa(s.Successes),
+			strconv.FormatFloat(s.Ratio, 'f', 2, 64),
+			s.LastAttempt.Format(time.RFC3339),
+			resultString(s.LastSuccess),
+			s.LastError,
+		})
+	}
+
+	if summaries == nil {
+		summaries = []*PeerSummary{}
+	}
+
+	return writeOutput(os.Stdout, format, summaries, header, rows)
+}
+
+func resultString(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
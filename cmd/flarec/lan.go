@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p/p2p/discovery"
+)
+
+const (
+	mdnsServiceTag = "_flare-test._udp"
+	mdnsInterval   = time.Minute
+)
+
+// Network classification of connection attempts
+const (
+	NetworkSameLAN      = "same-lan"
+	NetworkSamePublicIP = "same-public-ip"
+	NetworkDifferent    = "different-networks"
+)
+
+// LANPeers tracks peers discovered in the local network with mDNS.
+// We only note their presence; we never dial the discovered addresses, as that would
+// defeat the purpose of the test.
+type LANPeers struct {
+	sync.Mutex
+
+	svc   discovery.Service
+	peers map[peer.ID]struct{}
+}
+
+var _ discovery.Notifee = (*LANPeers)(nil)
+
+func NewLANPeers(h host.Host) (*LANPeers, error) {
+	svc, err := discovery.NewMdnsService(context.Background(), h, mdnsInterval, mdnsServiceTag)
+	if err != nil {
+		return nil, err
+	}
+
+	lan := &LANPeers{
+		svc:   svc,
+		peers: make(map[peer.ID]struct{}),
+	}
+	svc.RegisterNotifee(lan)
+
+	return lan, nil
+}
+
+func (l *LANPeers) HandlePeerFound(pi peer.AddrInfo) {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.peers[pi.ID]; !ok {
+		log.Debugf("discovered LAN peer %s", pi.ID)
+		l.peers[pi.ID] = struct{}{}
+	}
+}
+
+func (l *LANPeers) IsLANPeer(p peer.ID) bool {
+	if l == nil {
+		return false
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	_, ok := l.peers[p]
+	return ok
+}
+
+func (l *LANPeers) Close() error {
+	return l.svc.Close()
+}
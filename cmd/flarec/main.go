@@ -1,40 +1,57 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/user"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/vyzo/libp2p-flare-test/util"
 
-	"github.com/libp2p/go-libp2p"
-	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 
-	noise "github.com/libp2p/go-libp2p-noise"
-	quic "github.com/libp2p/go-libp2p-quic-transport"
-	tls "github.com/libp2p/go-libp2p-tls"
-	tcp "github.com/libp2p/go-tcp-transport"
-
 	logging "github.com/ipfs/go-log"
 )
 
 var log = logging.Logger("flare")
 
+// AdminKey is the pinned admin public key in base64, which can be set at build time with
+// -ldflags "-X main.AdminKey=..."; configurations must be signed by it.
+var AdminKey string
+
+// ClientVersion is the agent version of the client, which is checked by the servers.
+const ClientVersion = "flarec/0.2"
+
 func init() {
-	identify.ClientVersion = "flarec/0.1"
+	identify.ClientVersion = ClientVersion
 	logging.SetLogLevel("flare", "DEBUG")
 	logging.SetLogLevel("p2p-holepunch", "DEBUG")
 	logging.SetLogLevel("p2p-circuit", "DEBUG")
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		var cmd func(prog string, args []string) error
+		switch os.Args[1] {
+		case "identity":
+			cmd = util.IdentityCommand
+		case "history":
+			cmd = HistoryCommand
+		}
+		if cmd != nil {
+			if err := cmd(os.Args[0], os.Args[2:]); err != nil {
+				fatalf("%s", err)
+			}
+			return
+		}
+	}
+
 	idTCPPath := flag.String("idTCP", "identity-tcp", "identity key file path for TCP host")
 	idUDPPath := flag.String("idUDP", "identity-udp", "identity key file path for UDP host")
 	cfgPath := flag.String("config", "config.json", "json configuration file")
@@ -42,22 +59,53 @@ func main() {
 	enableUDP := flag.Bool("udp", true, "enable UDP host")
 	listPeers := flag.Bool("listPeers", false, "list peers and exit")
 	eagerTest := flag.Bool("eagerTest", false, "eagerly try to hole punch with all known peers and exit")
+	format := flag.String("format", FormatTable, "output format of -listPeers and -eagerTest: table, json or csv")
+	minPeers := flag.Int("minPeers", 0, "minimum number of peers for -listPeers and -eagerTest; exits with status 3 if not met")
+	minSuccessRate := flag.Float64("minSuccessRate", 0, "minimum success rate, between 0 and 1, for -eagerTest; exits with status 3 if not met")
 	nickname := flag.String("nick", "", "nickname for peer; defaults to the current user login id")
 	quiet := flag.Bool("quiet", false, "only log errors")
-	flag.Parse()
+	mdns := flag.Bool("mdns", false, "discover peers in the local network with mDNS")
+	domains := flag.String("domains", "", "comma separated list of domains to test; defaults to all configured domains")
+	adminKeyStr := flag.String("adminKey", "", "pinned admin public key, in base64 or as a file path; requires a signed config. Not allowed if a key was pinned at build time")
+	passFile := flag.String("passphraseFile", "", "file containing the identity passphrase")
+	historyPath := flag.String("history", DefaultHistoryPath, "local results history database path; empty to disable")
+	encrypt := flag.Bool("encrypt", false, "encrypt identities with a passphrase; prompted for unless in a passphrase file or $"+util.PassphraseEnv)
+
+	// the connect command shares the client options
+	var connectTarget string
+	if len(os.Args) > 1 && os.Args[1] == "connect" {
+		flag.Usage = func() {
+			fmt.Fprintf(os.Stderr, connectUsage, os.Args[0])
+			flag.PrintDefaults()
+		}
+		flag.CommandLine.Parse(os.Args[2:])
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(connectErrorStatus)
+		}
+		connectTarget = flag.Arg(0)
+	} else {
+		flag.Parse()
+	}
 
-	if *quiet {
-		logging.SetLogLevel("*", "ERROR")
+	if err := checkFormat(*format); err != nil {
+		fatalf("%s", err)
 	}
 
-	persistentIds := !*listPeers && !*eagerTest
+	// exit with the status of the command, after the deferred cleanups have run
+	exitStatus := 0
+	defer func() {
+		if exitStatus != 0 {
+			os.Exit(exitStatus)
+		}
+	}()
 
-	var cfg Config
-	err := util.LoadConfig(*cfgPath, &cfg)
-	if err != nil {
-		fatalf("error loading config: %s", err)
+	if *quiet {
+		logging.SetLogLevel("*", "ERROR")
 	}
 
+	persistentIds := !*listPeers && !*eagerTest && connectTarget == ""
+
 	nick := *nickname
 	if nick == "" {
 		user, err := user.Current()
@@ -67,68 +115,99 @@ func main() {
 		nick = user.Username
 	}
 
-	var clients []*Client
-
-	if *enableTCP {
-		var privk crypto.PrivKey
-		if persistentIds {
-			privk, err = util.LoadIdentity(*idTCPPath)
-		} else {
-			privk, err = util.GenerateIdentity()
+	enabled := make(map[string]bool)
+	if *domains != "" {
+		for _, name := range strings.Split(*domains, ",") {
+			enabled[strings.TrimSpace(name)] = true
 		}
-		if err != nil {
-			fatalf("error loading TCP identity: %s", err)
+	}
+	isEnabled := func(name string) bool {
+		if len(enabled) > 0 && !enabled[name] {
+			return false
 		}
+		return !((name == "TCP" && !*enableTCP) || (name == "UDP" && !*enableUDP))
+	}
 
-		id, err := peer.IDFromPrivateKey(privk)
-		if err != nil {
-			fatalf("error extracing peer ID: %s", err)
+	// a key pinned at build time cannot be replaced or disabled from the command line
+	if AdminKey != "" {
+		if isFlagSet("adminKey") {
+			fatalf("the admin key is pinned at build time; -adminKey is not allowed")
 		}
+		*adminKeyStr = AdminKey
+	}
 
-		tracer, err := NewTracer(&cfg, id, "TCP", nick)
+	var adminKey crypto.PubKey
+	if *adminKeyStr != "" {
+		var err error
+		adminKey, err = util.ParseAdminKey(*adminKeyStr)
 		if err != nil {
-			fatalf("error creating tracer: %s", err)
+			fatalf("error parsing admin key: %s", err)
 		}
-		defer tracer.Close()
+	}
 
-		cm := NewConnManager()
-		defer cm.Close()
+	var cfg Config
+	verified, err := util.LoadSignedConfig(*cfgPath, adminKey, &cfg)
+	if err != nil {
+		fatalf("error loading config: %s", err)
+	}
+	if !verified {
+		log.Warnf("no admin key pinned; configuration is not authenticated")
+	}
 
-		var opts []libp2p.Option
-		opts = append(opts,
-			libp2p.Identity(privk),
-			libp2p.NoTransports,
-			libp2p.Security(noise.ID, noise.New),
-			libp2p.Security(tls.ID, tls.New),
-			libp2p.Transport(tcp.NewTCPTransport),
-			libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"),
-			libp2p.ConnectionManager(cm),
-			libp2p.EnableRelay(),
-			libp2p.EnableHolePunching(holepunch.WithTracer(tracer)),
-			libp2p.ForceReachabilityPrivate(),
-		)
-
-		host, err := libp2p.New(context.Background(), opts...)
-		if err != nil {
-			fatalf("error constructing TCP host: %s", err)
+	err = util.LoadEnvSigned(ConfigEnvPrefix, &cfg, verified)
+	if err != nil {
+		fatalf("error loading config: %s", err)
+	}
+
+	err = cfg.Validate(isEnabled)
+	if err != nil {
+		fatalf("invalid config: %s", err)
+	}
+
+	updater := NewConfigUpdater(*cfgPath, adminKey, isEnabled, &cfg)
+	err = updater.LoadPersisted()
+	if err != nil {
+		fatalf("error loading config update: %s", err)
+	}
+
+	pass := &util.Passphrase{File: *passFile, Encrypt: *encrypt}
+
+	var history *History
+	if *historyPath != "" {
+		history = NewHistory(*historyPath)
+	}
+
+	var clients []*Client
+
+	for _, dc := range cfg.GetDomains() {
+		if !isEnabled(dc.Name) {
+			continue
 		}
 
-		client, err := NewClient(host, tracer, &cfg, "TCP", nick)
+		domain, err := NewDomain(dc)
 		if err != nil {
-			fatalf("error creating client: %s", err)
+			fatalf("error configuring domain: %s", err)
+		}
+
+		switch dc.Name {
+		case "TCP":
+			if isFlagSet("idTCP") || dc.Identity == "" {
+				domain.Identity = *idTCPPath
+			}
+		case "UDP":
+			if isFlagSet("idUDP") || dc.Identity == "" {
+				domain.Identity = *idUDPPath
+			}
 		}
-		clients = append(clients, client)
-	}
 
-	if *enableUDP {
 		var privk crypto.PrivKey
 		if persistentIds {
-			privk, err = util.LoadIdentity(*idUDPPath)
+			privk, err = util.LoadIdentity(domain.Identity, pass)
 		} else {
 			privk, err = util.GenerateIdentity()
 		}
 		if err != nil {
-			fatalf("error loading UDP identity: %s", err)
+			fatalf("error loading %s identity: %s", domain.Name, err)
 		}
 
 		id, err := peer.IDFromPrivateKey(privk)
@@ -136,75 +215,76 @@ func main() {
 			fatalf("error extracing peer ID: %s", err)
 		}
 
-		tracer, err := NewTracer(&cfg, id, "UDP", nick)
+		if persistentIds {
+			name := domain.Name
+			go util.WatchRotation(domain.Identity, id, util.RotationCheckInterval, func(r *util.Rotation) {
+				log.Warnf("%s identity rotated to %s; restart before %s, when the running identity retires", name, r.Current, r.Time.Format(time.RFC3339))
+			})
+		}
+
+		tracer, err := NewTracer(&cfg, id, domain, nick)
 		if err != nil {
 			fatalf("error creating tracer: %s", err)
 		}
 		defer tracer.Close()
+		if connectTarget != "" {
+			tracer.SetVerbose(true)
+		}
 
-		cm := NewConnManager()
+		cm := NewConnManager(&cfg)
 		defer cm.Close()
 
-		var opts []libp2p.Option
-		opts = append(opts,
-			libp2p.Identity(privk),
-			libp2p.Security(noise.ID, noise.New),
-			libp2p.Security(tls.ID, tls.New),
-			libp2p.NoTransports,
-			libp2p.Transport(quic.NewTransport),
-			libp2p.ListenAddrStrings("/ip4/0.0.0.0/udp/0/quic"),
-			libp2p.ConnectionManager(cm),
-			libp2p.EnableRelay(),
-			libp2p.EnableHolePunching(holepunch.WithTracer(tracer)),
-			libp2p.ForceReachabilityPrivate(),
-		)
-
-		host, err := libp2p.New(context.Background(), opts...)
+		host, err := domain.NewHost(privk, tracer, cm)
 		if err != nil {
-			fatalf("error constructing UDP host: %s", err)
+			fatalf("error constructing %s host: %s", domain.Name, err)
 		}
 
-		client, err := NewClient(host, tracer, &cfg, "UDP", nick)
+		client, err := NewClient(host, tracer, history, &cfg, domain, nick)
 		if err != nil {
 			fatalf("error creating client: %s", err)
 		}
-		clients = append(clients, client)
-	}
+		updater.AddClient(client)
 
-	if *listPeers {
-		for _, c := range clients {
-			peers, err := c.ListPeers()
+		if *mdns {
+			err = client.DiscoverLANPeers()
 			if err != nil {
-				fatalf("error retrieving peers: %s", err)
-			}
-
-			fmt.Printf("%s peers:\n", c.Domain())
-			for _, p := range peers {
-				fmt.Printf("\t%s [%s]\n", p.Info.ID, p.Nick)
+				fatalf("error enabling LAN peer discovery: %s", err)
 			}
 		}
 
+		clients = append(clients, client)
+	}
+
+	if connectTarget != "" {
+		exitStatus = connectCommand(clients, connectTarget)
 		return
 	}
 
-	if *eagerTest {
-		for _, c := range clients {
-			fmt.Printf("Eagerly testing with %s\n", c.Domain())
-			peers, err := c.ListPeers()
-			if err != nil {
-				fatalf("error retrieving peers: %s", err)
-			}
+	if *listPeers {
+		peers, ok := listPeersCommand(clients, *format)
+		switch {
+		case !checkThresholds(peers, nil, *minPeers, 0):
+			exitStatus = thresholdStatus
+		case !ok:
+			exitStatus = domainErrorStatus
+		}
+		return
+	}
 
-			for _, p := range peers {
-				err := c.Connect(p)
-				if err != nil {
-					fmt.Printf("\t%s [%s]: %s\n", p.Info.ID, p.Nick, err)
-				} else {
-					fmt.Printf("\t%s [%s]: OK\n", p.Info.ID, p.Nick)
-				}
+	if *eagerTest {
+		tests, ok := eagerTestCommand(clients, *format)
+		peers := 0
+		for _, r := range tests {
+			if r.PeerID != "" {
+				peers++
 			}
 		}
-
+		switch {
+		case !checkThresholds(peers, tests, *minPeers, *minSuccessRate):
+			exitStatus = thresholdStatus
+		case !ok:
+			exitStatus = domainErrorStatus
+		}
 		return
 	}
 
@@ -223,7 +303,17 @@ func main() {
 	wg.Wait()
 }
 
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func fatalf(template string, args ...interface{}) {
-	fmt.Printf(template+"\n", args...)
+	fmt.Fprintf(os.Stderr, template+"\n", args...)
 	os.Exit(1)
 }
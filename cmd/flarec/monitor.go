@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/vyzo/libp2p-flare-test/proto"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+const (
+	monitorTag      = "flare-monitor"
+	monitorPingSize = 32
+	monitorTimeout  = time.Minute
+)
+
+var (
+	DefaultMonitorPeriod   = time.Hour
+	DefaultMonitorInterval = time.Minute
+)
+
+var errConnClosed = errors.New("connection closed")
+
+// Monitor keeps direct connections established by hole punching alive for a while and pings
+// them periodically, tracing their demise so that we can estimate NAT binding timeouts.
+type Monitor struct {
+	sync.Mutex
+
+	host     host.Host
+	tracer   *Tracer
+	period   time.Duration
+	interval time.Duration
+
+	conns map[network.Conn]*monitoredConn
+}
+
+type monitoredConn struct {
+	ci       *ClientInfo
+	conn     network.Conn
+	lastSeen time.Time
+	done     chan struct{}
+}
+
+func NewMonitor(h host.Host, tracer *Tracer, cfg *Config) *Monitor {
+	m := &Monitor{
+		host:     h,
+		tracer:   tracer,
+		period:   cfg.MonitorPeriod.Or(DefaultMonitorPeriod),
+		interval: cfg.MonitorInterval.Or(DefaultMonitorInterval),
+		conns:    make(map[network.Conn]*monitoredConn),
+	}
+	h.SetStreamHandler(proto.MonitorProtoID, m.handleStream)
+	h.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: m.disconnect,
+	})
+	return m
+}
+
+// SetTiming sets the monitoring period and ping interval for newly monitored connections.
+func (m *Monitor) SetTiming(period, interval time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.period = period
+	m.interval = interval
+}
+
+// Watch starts monitoring the direct connection to a peer, if there is one.
+func (m *Monitor) Watch(ci *ClientInfo) {
+	var conn network.Conn
+	for _, c := range m.host.Network().ConnsToPeer(ci.Info.ID) {
+		if !isRelayConn(c) {
+			conn = c
+			break
+		}
+	}
+
+	if conn == nil {
+		return
+	}
+
+	m.Lock()
+	if _, ok := m.conns[conn]; ok {
+		m.Unlock()
+		return
+	}
+
+	mc := &monitoredConn{
+		ci:       ci,
+		conn:     conn,
+		lastSeen: conn.Stat().Opened,
+		done:     make(chan struct{}),
+	}
+	m.conns[conn] = mc
+	period := m.period
+	m.Unlock()
+
+	log.Debugf("monitoring direct connection to %s [%s] for %s", ci.Info.ID, ci.Nick, period)
+
+	m.host.ConnManager().Protect(ci.Info.ID, monitorTag)
+	go m.monitor(mc)
+}
+
+func (m *Monitor) monitor(mc *monitoredConn) {
+	defer m.host.ConnManager().Unprotect(mc.ci.Info.ID, monitorTag)
+
+	ctx, cancel := context.WithTimeout(context.Background(), monitorTimeout)
+	s, err := m.host.NewStream(network.WithNoDial(ctx, "monitor"), mc.ci.Info.ID, proto.MonitorProtoID)
+	cancel()
+
+	// the peer may not support monitoring, e.g. an older client; the connection is still good, so
+	// leave it alone and just stop monitoring it
+	if err != nil {
+		m.unwatch(mc, fmt.Errorf("error opening monitor stream: %w", err))
+		return
+	}
+
+	if s.Conn() != mc.conn {
+		s.Reset()
+		m.unwatch(mc, fmt.Errorf("monitor stream opened in a different connection"))
+		return
+	}
+
+	m.Lock()
+	period, interval := m.period, m.interval
+	m.Unlock()
+
+	expire := time.NewTimer(period)
+	defer expire.Stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := m.ping(s)
+			if err != nil {
+				s.Reset()
+				m.dead(mc, err)
+				return
+			}
+
+			m.Lock()
+			mc.lastSeen = time.Now()
+			m.Unlock()
+
+		case <-expire.C:
+			m.Lock()
+			delete(m.conns, mc.conn)
+			m.Unlock()
+
+			log.Debugf("direct connection to %s [%s] survived monitoring period", mc.ci.Info.ID, mc.ci.Nick)
+			s.Close()
+			return
+
+		case <-mc.done:
+			s.Reset()
+			return
+		}
+	}
+}
+
+func (m *Monitor) ping(s network.Stream) error {
+	s.SetDeadline(time.Now().Add(monitorTimeout))
+	defer s.SetDeadline(time.Time{})
+
+	buf := make([]byte, monitorPingSize)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("error generating ping: %w", err)
+	}
+
+	if _, err := s.Write(buf); err != nil {
+		return fmt.Errorf("error writing ping: %w", err)
+	}
+
+	rbuf := make([]byte, monitorPingSize)
+	if _, err := io.ReadFull(s, rbuf); err != nil {
+		return fmt.Errorf("error reading pong: %w", err)
+	}
+
+	if !bytes.Equal(buf, rbuf) {
+		return fmt.Errorf("bad pong")
+	}
+
+	return nil
+}
+
+// dead records the death of a monitored connection; it is called either because
+// the connection was closed or because pinging failed, in which case the connection is closed.
+func (m *Monitor) dead(mc *monitoredConn, reason error) {
+	now := time.Now()
+
+	m.Lock()
+	_, ok := m.conns[mc.conn]
+	delete(m.conns, mc.conn)
+	lastSeen := mc.lastSeen
+	m.Unlock()
+
+	if !ok {
+		return
+	}
+
+	close(mc.done)
+	mc.conn.Close()
+
+	lifetime := now.Sub(mc.conn.Stat().Opened)
+	idle := now.Sub(lastSeen)
+
+	log.Infof("direct connection to %s [%s] died after %s (idle for %s): %s",
+		mc.ci.Info.ID, mc.ci.Nick, lifetime, idle, reason)
+	m.tracer.Disconnect(mc.ci, lifetime, idle, reason)
+}
+
+// unwatch stops monitoring a connection that cannot be monitored, without closing it or
+// recording its death.
+func (m *Monitor) unwatch(mc *monitoredConn, reason error) {
+	m.Lock()
+	delete(m.conns, mc.conn)
+	m.Unlock()
+
+	log.Debugf("not monitoring direct connection to %s [%s]: %s", mc.ci.Info.ID, mc.ci.Nick, reason)
+}
+
+func (m *Monitor) disconnect(_ network.Network, conn network.Conn) {
+	m.Lock()
+	mc, ok := m.conns[conn]
+	m.Unlock()
+
+	if ok {
+		m.dead(mc, errConnClosed)
+	}
+}
+
+func (m *Monitor) handleStream(s network.Stream) {
+	defer s.Close()
+
+	// echo back pings until the remote side is done
+	buf := make([]byte, monitorPingSize)
+	for {
+		if _, err := io.ReadFull(s, buf); err != nil {
+			if err != io.EOF {
+				s.Reset()
+			}
+			return
+		}
+
+		if _, err := s.Write(buf); err != nil {
+			s.Reset()
+			return
+		}
+	}
+}
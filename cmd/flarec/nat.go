@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	pb "github.com/vyzo/libp2p-flare-test/pb"
+	"github.com/vyzo/libp2p-flare-test/proto"
+
+	"github.com/libp2p/go-libp2p-core/network"
+
+	"github.com/libp2p/go-msgio/protoio"
+	"github.com/libp2p/go-reuseport"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// NAT behavior classes, as defined in RFC 4787
+const (
+	NATBehaviorUnknown         = "unknown"
+	NATEndpointIndependent     = "endpoint-independent"
+	NATAddressDependent        = "address-dependent"
+	NATAddressAndPortDependent = "address-and-port-dependent"
+)
+
+const (
+	echoTimeout     = 2 * time.Second
+	echoAttempts    = 3
+	echoDialTimeout = 10 * time.Second
+	echoMaxMsgSize  = 4096
+)
+
+// NATBehavior is the classification of our NAT's behavior, as determined by the
+// address echo service in flared.
+type NATBehavior struct {
+	Mapping          string
+	Filtering        string
+	PortPreservation bool
+}
+
+// DeviceType maps the behavior to the coarse NAT device type used by libp2p.
+func (b *NATBehavior) DeviceType() network.NATDeviceType {
+	switch b.Mapping {
+	case NATEndpointIndependent:
+		return network.NATDeviceTypeCone
+	case NATAddressDependent, NATAddressAndPortDependent:
+		return network.NATDeviceTypeSymmetric
+	default:
+		return network.NATDeviceTypeUnknown
+	}
+}
+
+type endpoint struct {
+	ip   net.IP
+	port int
+}
+
+func (e endpoint) equal(other endpoint) bool {
+	return e.ip.Equal(other.ip) && e.port == other.port
+}
+
+func (e endpoint) String() string {
+	return net.JoinHostPort(e.ip.String(), fmt.Sprint(e.port))
+}
+
+type echoMapping struct {
+	server, mapped endpoint
+}
+
+// probeNATBehavior classifies NAT behavior using the address echo service at the given addresses;
+// all addresses must be of the same transport.
+func probeNATBehavior(echoAddrs []ma.Multiaddr) (*NATBehavior, error) {
+	if len(echoAddrs) == 0 {
+		return nil, fmt.Errorf("no echo addresses")
+	}
+
+	var servers []endpoint
+	var netw string
+	for _, a := range echoAddrs {
+		n, srv, err := parseEndpoint(a)
+		if err != nil {
+			return nil, err
+		}
+
+		if netw == "" {
+			netw = n
+		} else if netw != n {
+			return nil, fmt.Errorf("echo address %s does not match network %s", a, netw)
+		}
+
+		servers = append(servers, srv)
+	}
+
+	switch {
+	case strings.HasPrefix(netw, "udp"):
+		return probeUDP(netw, servers)
+	case strings.HasPrefix(netw, "tcp"):
+		return probeTCP(netw, servers)
+	default:
+		return nil, fmt.Errorf("unsupported echo network %s", netw)
+	}
+}
+
+func probeUDP(netw string, servers []endpoint) (*NATBehavior, error) {
+	pc, err := net.ListenPacket(netw, ":0")
+	if err != nil {
+		return nil, fmt.Errorf("error creating UDP socket: %w", err)
+	}
+	defer pc.Close()
+
+	var mappings []echoMapping
+	for _, srv := range servers {
+		mapped, err := echoUDP(pc, srv, false, false)
+		if err != nil {
+			log.Debugf("error probing echo server %s: %s", srv, err)
+			continue
+		}
+
+		mappings = append(mappings, echoMapping{server: srv, mapped: mapped})
+	}
+
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("no response from echo servers")
+	}
+
+	return &NATBehavior{
+		Mapping:          classifyMapping(mappings),
+		Filtering:        probeUDPFiltering(netw, servers),
+		PortPreservation: portPreserved(pc.LocalAddr().(*net.UDPAddr).Port, mappings),
+	}, nil
+}
+
+// probeUDPFiltering performs the RFC 5780 filtering tests, asking the server to respond
+// from a different address and/or port.
+func probeUDPFiltering(netw string, servers []endpoint) string {
+	pc, err := net.ListenPacket(netw, ":0")
+	if err != nil {
+		log.Debugf("error creating UDP socket: %s", err)
+		return NATBehaviorUnknown
+	}
+	defer pc.Close()
+
+	// establish the mapping
+	primary := servers[0]
+	if _, err := echoUDP(pc, primary, false, false); err != nil {
+		log.Debugf("error probing echo server %s: %s", primary, err)
+		return NATBehaviorUnknown
+	}
+
+	return classifyFiltering(servers, func(changeIP, changePort bool) bool {
+		_, err := echoUDP(pc, primary, changeIP, changePort)
+		return err == nil
+	})
+}
+
+// classifyFiltering classifies filtering behavior from whether responses sent by the primary
+// server from a different address and/or port get through; what can be tested depends on the
+// endpoints of the other servers.
+func classifyFiltering(servers []endpoint, echo func(changeIP, changePort bool) bool) string {
+	primary := servers[0]
+	canChangeIP := false
+	canChangePort := false
+	for _, srv := range servers[1:] {
+		if !srv.ip.Equal(primary.ip) && srv.port != primary.port {
+			canChangeIP = true
+		}
+		if srv.ip.Equal(primary.ip) && srv.port != primary.port {
+			canChangePort = true
+		}
+	}
+
+	if canChangeIP && echo(true, true) {
+		return NATEndpointIndependent
+	}
+
+	if !canChangePort {
+		return NATBehaviorUnknown
+	}
+
+	if echo(false, true) {
+		if canChangeIP {
+			return NATAddressDependent
+		}
+		// we can't tell whether the filter is endpoint independent
+		return NATBehaviorUnknown
+	}
+
+	return NATAddressAndPortDependent
+}
+
+func echoUDP(pc net.PacketConn, srv endpoint, changeIP, changePort bool) (endpoint, error) {
+	nonce, err := proto.Nonce()
+	if err != nil {
+		return endpoint{}, err
+	}
+
+	req := &pb.EchoRequest{Nonce: nonce}
+	if changeIP {
+		req.ChangeIP = &changeIP
+	}
+	if changePort {
+		req.ChangePort = &changePort
+	}
+
+	data, err := req.Marshal()
+	if err != nil {
+		return endpoint{}, err
+	}
+
+	raddr := &net.UDPAddr{IP: srv.ip, Port: srv.port}
+	buf := make([]byte, echoMaxMsgSize)
+	for i := 0; i < echoAttempts; i++ {
+		if _, err := pc.WriteTo(data, raddr); err != nil {
+			return endpoint{}, fmt.Errorf("error writing echo request: %w", err)
+		}
+
+		pc.SetReadDeadline(time.Now().Add(echoTimeout))
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					break
+				}
+				return endpoint{}, fmt.Errorf("error reading echo response: %w", err)
+			}
+
+			var resp pb.EchoResponse
+			if err := resp.Unmarshal(buf[:n]); err != nil {
+				continue
+			}
+
+			if !bytes.Equal(resp.GetNonce(), nonce) {
+				// stale response from an earlier attempt
+				continue
+			}
+
+			return parseObservedAddr(resp.GetObservedAddr())
+		}
+	}
+
+	return endpoint{}, fmt.Errorf("timed out waiting for echo response")
+}
+
+// probeTCP classifies mapping behavior for TCP, by connecting to the echo servers from the
+// same local port. Filtering behavior is not tested for TCP.
+func probeTCP(netw string, servers []endpoint) (*NATBehavior, error) {
+	lc := net.ListenConfig{Control: reuseport.Control}
+	l, err := lc.Listen(context.Background(), netw, ":0")
+	if err != nil {
+		return nil, fmt.Errorf("error creating TCP socket: %w", err)
+	}
+	defer l.Close()
+
+	laddr := l.Addr().(*net.TCPAddr)
+
+	var mappings []echoMapping
+	for _, srv := range servers {
+		mapped, err := echoTCP(netw, laddr, srv)
+		if err != nil {
+			log.Debugf("error probing echo server %s: %s", srv, err)
+			continue
+		}
+
+		mappings = append(mappings, echoMapping{server: srv, mapped: mapped})
+	}
+
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("no response from echo servers")
+	}
+
+	return &NATBehavior{
+		Mapping:          classifyMapping(mappings),
+		Filtering:        NATBehaviorUnknown,
+		PortPreservation: portPreserved(laddr.Port, mappings),
+	}, nil
+}
+
+func echoTCP(netw string, laddr *net.TCPAddr, srv endpoint) (endpoint, error) {
+	d := net.Dialer{
+		Control:   reuseport.Control,
+		LocalAddr: laddr,
+		Timeout:   echoDialTimeout,
+	}
+
+	conn, err := d.Dial(netw, srv.String())
+	if err != nil {
+		return endpoint{}, fmt.Errorf("error connecting to echo server: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(echoDialTimeout))
+
+	nonce, err := proto.Nonce()
+	if err != nil {
+		return endpoint{}, err
+	}
+
+	wr := protoio.NewDelimitedWriter(conn)
+	rd := protoio.NewDelimitedReader(conn, echoMaxMsgSize)
+
+	if err := wr.WriteMsg(&pb.EchoRequest{Nonce: nonce}); err != nil {
+		return endpoint{}, fmt.Errorf("error writing echo request: %w", err)
+	}
+
+	var resp pb.EchoResponse
+	if err := rd.ReadMsg(&resp); err != nil {
+		return endpoint{}, fmt.Errorf("error reading echo response: %w", err)
+	}
+
+	if !bytes.Equal(resp.GetNonce(), nonce) {
+		return endpoint{}, fmt.Errorf("echo response nonce mismatch")
+	}
+
+	return parseObservedAddr(resp.GetObservedAddr())
+}
+
+// classifyMapping classifies mapping behavior by comparing the mapped endpoints observed
+// by the different echo servers.
+func classifyMapping(mappings []echoMapping) string {
+	if len(mappings) < 2 {
+		return NATBehaviorUnknown
+	}
+
+	independent := true
+	for _, m := range mappings[1:] {
+		if !m.mapped.equal(mappings[0].mapped) {
+			independent = false
+			break
+		}
+	}
+
+	if independent {
+		return NATEndpointIndependent
+	}
+
+	// the mapping depends on the server endpoint; check whether it depends on the port
+	addrDependent := false
+	for i, mi := range mappings {
+		for _, mj := range mappings[i+1:] {
+			if !mi.server.ip.Equal(mj.server.ip) {
+				continue
+			}
+
+			if !mi.mapped.equal(mj.mapped) {
+				return NATAddressAndPortDependent
+			}
+
+			addrDependent = true
+		}
+	}
+
+	if addrDependent {
+		return NATAddressDependent
+	}
+
+	return NATBehaviorUnknown
+}
+
+func portPreserved(port int, mappings []echoMapping) bool {
+	for _, m := range mappings {
+		if m.mapped.port != port {
+			return false
+		}
+	}
+	return true
+}
+
+func parseEndpoint(a ma.Multiaddr) (string, endpoint, error) {
+	na, err := manet.ToNetAddr(a)
+	if err != nil {
+		return "", endpoint{}, fmt.Errorf("error parsing echo address %s: %w", a, err)
+	}
+
+	switch na := na.(type) {
+	case *net.UDPAddr:
+		return na.Network() + ipVersion(na.IP), endpoint{ip: na.IP, port: na.Port}, nil
+	case *net.TCPAddr:
+		return na.Network() + ipVersion(na.IP), endpoint{ip: na.IP, port: na.Port}, nil
+	default:
+		return "", endpoint{}, fmt.Errorf("unsupported echo address %s", a)
+	}
+}
+
+func parseObservedAddr(ab []byte) (endpoint, error) {
+	a, err := ma.NewMultiaddrBytes(ab)
+	if err != nil {
+		return endpoint{}, fmt.Errorf("error parsing observed address: %w", err)
+	}
+
+	_, ep, err := parseEndpoint(a)
+	return ep, err
+}
+
+func ipVersion(ip net.IP) string {
+	if ip.To4() != nil {
+		return "4"
+	}
+	return "6"
+}
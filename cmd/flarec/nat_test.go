@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func testEndpoint(ip string, port int) endpoint {
+	return endpoint{ip: net.ParseIP(ip), port: port}
+}
+
+func TestClassifyMapping(t *testing.T) {
+	srvA1 := testEndpoint("10.0.0.1", 3478)
+	srvA2 := testEndpoint("10.0.0.1", 3479)
+	srvB1 := testEndpoint("10.0.0.2", 3478)
+
+	cases := []struct {
+		name     string
+		mappings []echoMapping
+		expect   string
+	}{
+		{
+			name:     "single server",
+			mappings: []echoMapping{{srvA1, testEndpoint("1.2.3.4", 4001)}},
+			expect:   NATBehaviorUnknown,
+		},
+		{
+			name: "endpoint independent",
+			mappings: []echoMapping{
+				{srvA1, testEndpoint("1.2.3.4", 4001)},
+				{srvA2, testEndpoint("1.2.3.4", 4001)},
+				{srvB1, testEndpoint("1.2.3.4", 4001)},
+			},
+			expect: NATEndpointIndependent,
+		},
+		{
+			name: "address dependent",
+			mappings: []echoMapping{
+				{srvA1, testEndpoint("1.2.3.4", 4001)},
+				{srvA2, testEndpoint("1.2.3.4", 4001)},
+				{srvB1, testEndpoint("1.2.3.4", 5001)},
+			},
+			expect: NATAddressDependent,
+		},
+		{
+			name: "address and port dependent",
+			mappings: []echoMapping{
+				{srvA1, testEndpoint("1.2.3.4", 4001)},
+				{srvA2, testEndpoint("1.2.3.4", 4002)},
+				{srvB1, testEndpoint("1.2.3.4", 5001)},
+			},
+			expect: NATAddressAndPortDependent,
+		},
+		{
+			name: "dependent on distinct addresses only",
+			mappings: []echoMapping{
+				{srvA1, testEndpoint("1.2.3.4", 4001)},
+				{srvB1, testEndpoint("1.2.3.4", 5001)},
+			},
+			expect: NATBehaviorUnknown,
+		},
+		{
+			name: "different public IP",
+			mappings: []echoMapping{
+				{srvA1, testEndpoint("1.2.3.4", 4001)},
+				{srvB1, testEndpoint("1.2.3.5", 4001)},
+			},
+			expect: NATBehaviorUnknown,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if result := classifyMapping(c.mappings); result != c.expect {
+				t.Fatalf("expected %s, got %s", c.expect, result)
+			}
+		})
+	}
+}
+
+func TestClassifyFiltering(t *testing.T) {
+	primary := testEndpoint("10.0.0.1", 3478)
+	otherPort := testEndpoint("10.0.0.1", 3479)
+	otherIP := testEndpoint("10.0.0.2", 3479)
+
+	cases := []struct {
+		name string
+		// the servers, with the primary first
+		servers []endpoint
+		// whether responses from a different address and port, or from a different port only,
+		// get through
+		changedIP, changedPort bool
+		expect                 string
+	}{
+		{
+			name:      "endpoint independent",
+			servers:   []endpoint{primary, otherPort, otherIP},
+			changedIP: true, changedPort: true,
+			expect: NATEndpointIndependent,
+		},
+		{
+			name:        "address dependent",
+			servers:     []endpoint{primary, otherPort, otherIP},
+			changedPort: true,
+			expect:      NATAddressDependent,
+		},
+		{
+			name:    "address and port dependent",
+			servers: []endpoint{primary, otherPort, otherIP},
+			expect:  NATAddressAndPortDependent,
+		},
+		{
+			name:      "endpoint independent without port change",
+			servers:   []endpoint{primary, otherIP},
+			changedIP: true, changedPort: true,
+			expect: NATEndpointIndependent,
+		},
+		{
+			name:    "no port change",
+			servers: []endpoint{primary, otherIP},
+			expect:  NATBehaviorUnknown,
+		},
+		{
+			name:        "no address change",
+			servers:     []endpoint{primary, otherPort},
+			changedIP:   true,
+			changedPort: true,
+			expect:      NATBehaviorUnknown,
+		},
+		{
+			name:    "address and port dependent without address change",
+			servers: []endpoint{primary, otherPort},
+			expect:  NATAddressAndPortDependent,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result := classifyFiltering(c.servers, func(changeIP, changePort bool) bool {
+				if changeIP {
+					return c.changedIP
+				}
+				return c.changedPort
+			})
+			if result != c.expect {
+				t.Fatalf("expected %s, got %s", c.expect, result)
+			}
+		})
+	}
+}
+
+func TestPortPreserved(t *testing.T) {
+	mappings := []echoMapping{
+		{testEndpoint("10.0.0.1", 3478), testEndpoint("1.2.3.4", 4001)},
+		{testEndpoint("10.0.0.2", 3478), testEndpoint("1.2.3.4", 4001)},
+	}
+
+	if !portPreserved(4001, mappings) {
+		t.Fatal("expected port to be preserved")
+	}
+	if portPreserved(4002, mappings) {
+		t.Fatal("expected port not to be preserved")
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+
+	circuit "github.com/libp2p/go-libp2p-circuit/v2/client"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	relayTag                 = "flare-relay"
+	reservationRefreshMargin = 15 * time.Minute
+	relayRetryInterval       = time.Minute
+	relayBackoffInterval     = 10 * time.Minute
+	relayLatencyTimeout      = 10 * time.Second
+)
+
+var (
+	errRelayDisconnected = errors.New("relay disconnected")
+	errRelayRemoved      = errors.New("relay removed from configuration")
+)
+
+type reservation struct {
+	relay   *peer.AddrInfo
+	rsvp    *circuit.Reservation
+	refresh time.Time
+}
+
+// connectToRelays reserves slots in the best relays and announces our relay addresses to the
+// server; the reservations are then maintained in the background.
+func (c *Client) connectToRelays() {
+	for {
+		c.reserveRelays()
+		if c.activeRelays() > 0 {
+			break
+		}
+
+		log.Warnf("could not reserve a slot in any relay; will retry in 1min")
+		time.Sleep(relayRetryInterval)
+	}
+
+	c.announce(c.relayAddrs())
+	go c.maintainReservations()
+}
+
+// reserveRelays fills up our reservations up to the configured relay count, selecting
+// relays by latency. It returns the number of new reservations.
+func (c *Client) reserveRelays() int {
+	c.relayMx.Lock()
+	want := c.domain.RelayCount - len(c.reservations)
+	c.relayMx.Unlock()
+
+	if want <= 0 {
+		return 0
+	}
+
+	count := 0
+	for _, relay := range c.selectRelays() {
+		rsvp, err := c.reserve(relay)
+		if err != nil {
+			log.Warnf("%s", err)
+			c.backoffRelay(relay.ID)
+			continue
+		}
+
+		c.addReservation(relay, rsvp)
+
+		count++
+		if count == want {
+			break
+		}
+	}
+
+	return count
+}
+
+// selectRelays returns the relays we don't have a reservation with, sorted by latency;
+// unreachable relays are omitted, and so are relays in backoff unless we have no reservations.
+func (c *Client) selectRelays() []*peer.AddrInfo {
+	type candidate struct {
+		relay *peer.AddrInfo
+		rtt   time.Duration
+	}
+
+	c.relayMx.Lock()
+	relays := c.domain.Relays
+	c.relayMx.Unlock()
+
+	var candidates []candidate
+	now := time.Now()
+	for _, relay := range relays {
+		c.relayMx.Lock()
+		_, active := c.reservations[relay.ID]
+		backoff := len(c.reservations) > 0 && now.Before(c.relayBackoff[relay.ID])
+		c.relayMx.Unlock()
+
+		if active || backoff {
+			continue
+		}
+
+		rtt, err := c.measureLatency(relay)
+		if err != nil {
+			log.Warnf("error measuring latency to relay %s: %s", relay.ID, err)
+			continue
+		}
+
+		log.Debugf("latency to relay %s is %s", relay.ID, rtt)
+		candidates = append(candidates, candidate{relay: relay, rtt: rtt})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].rtt < candidates[j].rtt
+	})
+
+	result := make([]*peer.AddrInfo, 0, len(candidates))
+	for _, cand := range candidates {
+		result = append(result, cand.relay)
+	}
+
+	return result
+}
+
+func (c *Client) measureLatency(relay *peer.AddrInfo) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), relayLatencyTimeout)
+	defer cancel()
+
+	err := c.host.Connect(ctx, *relay)
+	if err != nil {
+		return 0, fmt.Errorf("error connecting to relay: %w", err)
+	}
+
+	res := <-ping.Ping(ctx, c.host, relay.ID)
+	if res.Error != nil {
+		return 0, fmt.Errorf("error pinging relay: %w", res.Error)
+	}
+
+	return res.RTT, nil
+}
+
+// reserve connects to a relay and reserves a slot.
+func (c *Client) reserve(relay *peer.AddrInfo) (*circuit.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := c.host.Connect(ctx, *relay)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to relay %s: %w", relay.ID, err)
+	}
+
+	rsvp, err := circuit.Reserve(ctx, c.host, *relay)
+	if err != nil {
+		return nil, fmt.Errorf("error reserving slot in relay %s: %w", relay.ID, err)
+	}
+
+	return rsvp, nil
+}
+
+// maintainReservations refreshes reservations ahead of their expiration, fails over to other
+// relays when a relay rejects or drops us or the relays are reconfigured, and announces our relay
+// addresses whenever they change.
+func (c *Client) maintainReservations() {
+	for {
+		changed := false
+
+		timer := time.NewTimer(time.Until(c.nextRefresh()))
+		select {
+		case <-timer.C:
+		case p := <-c.relayDown:
+			timer.Stop()
+			changed = c.dropReservation(p, errRelayDisconnected)
+		case <-c.relayUpdate:
+			// the relays were reconfigured; reservations with removed relays have been dropped
+			timer.Stop()
+			changed = true
+		}
+
+		for _, r := range c.dueReservations() {
+			log.Debugf("refreshing reservation in relay %s", r.relay.ID)
+
+			rsvp, err := c.reserve(r.relay)
+			if err != nil {
+				log.Warnf("error refreshing reservation: %s", err)
+				c.dropReservation(r.relay.ID, err)
+				c.backoffRelay(r.relay.ID)
+				changed = true
+				continue
+			}
+
+			c.addReservation(r.relay, rsvp)
+			if !sameAddrs(r.rsvp.Addrs, rsvp.Addrs) {
+				changed = true
+			}
+		}
+
+		if c.reserveRelays() > 0 {
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+
+		err := c.connectToBootstrappers()
+		if err != nil {
+			log.Warnf("error connecting to bootstrappers: %s", err)
+		}
+
+		c.announce(c.relayAddrs())
+	}
+}
+
+func (c *Client) addReservation(relay *peer.AddrInfo, rsvp *circuit.Reservation) {
+	log.Infof("reserved slot in relay %s; expires at %s", relay.ID, rsvp.Expiration)
+
+	c.relayMx.Lock()
+	c.reservations[relay.ID] = &reservation{
+		relay:   relay,
+		rsvp:    rsvp,
+		refresh: time.Now().Add(refreshDelay(rsvp.Expiration)),
+	}
+	c.relayMx.Unlock()
+
+	c.host.ConnManager().Protect(relay.ID, relayTag)
+	c.tracer.Reservation(relay.ID, rsvp.Expiration, nil)
+}
+
+func (c *Client) dropReservation(p peer.ID, reason error) bool {
+	c.relayMx.Lock()
+	_, ok := c.reservations[p]
+	delete(c.reservations, p)
+	c.relayMx.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	log.Warnf("lost reservation in relay %s: %s", p, reason)
+	c.host.ConnManager().Unprotect(p, relayTag)
+	c.tracer.Reservation(p, time.Time{}, reason)
+	return true
+}
+
+func (c *Client) backoffRelay(p peer.ID) {
+	c.relayMx.Lock()
+	defer c.relayMx.Unlock()
+
+	c.relayBackoff[p] = time.Now().Add(relayBackoffInterval)
+}
+
+func (c *Client) activeRelays() int {
+	c.relayMx.Lock()
+	defer c.relayMx.Unlock()
+
+	return len(c.reservations)
+}
+
+// nextRefresh returns the time of the next reservation refresh or attempt to fill up our reservations.
+func (c *Client) nextRefresh() time.Time {
+	c.relayMx.Lock()
+	defer c.relayMx.Unlock()
+
+	var next time.Time
+	if len(c.reservations) < c.domain.RelayCount {
+		next = time.Now().Add(relayRetryInterval)
+	}
+
+	for _, r := range c.reservations {
+		if next.IsZero() || r.refresh.Before(next) {
+			next = r.refresh
+		}
+	}
+
+	return next
+}
+
+func (c *Client) dueReservations() []*reservation {
+	c.relayMx.Lock()
+	defer c.relayMx.Unlock()
+
+	var result []*reservation
+	now := time.Now()
+	for _, r := range c.reservations {
+		if !now.Before(r.refresh) {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
+
+// relayAddrs returns the circuit addresses from all our reservations.
+func (c *Client) relayAddrs() []ma.Multiaddr {
+	c.relayMx.Lock()
+	defer c.relayMx.Unlock()
+
+	var result []ma.Multiaddr
+	for _, r := range c.reservations {
+		result = append(result, r.rsvp.Addrs...)
+	}
+
+	return result
+}
+
+// relayDisconnected notifies the reservation maintenance loop that a relay has dropped us.
+func (c *Client) relayDisconnected(p peer.ID) {
+	c.relayMx.Lock()
+	_, ok := c.reservations[p]
+	c.relayMx.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case c.relayDown <- p:
+	default:
+	}
+}
+
+// sameAddrs returns true if a and b contain the same addresses, regardless of order.
+func sameAddrs(a, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := make(map[string]int, len(a))
+	for _, addr := range a {
+		count[string(addr.Bytes())]++
+	}
+	for _, addr := range b {
+		key := string(addr.Bytes())
+		if count[key] == 0 {
+			return false
+		}
+		count[key]--
+	}
+
+	return true
+}
+
+func refreshDelay(expiration time.Time) time.Duration {
+	ttl := time.Until(expiration)
+	if ttl > 2*reservationRefreshMargin {
+		return ttl - reservationRefreshMargin
+	}
+	return ttl / 2
+}
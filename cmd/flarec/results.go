@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Exit status of -listPeers and -eagerTest, other than 0 for success; 1 is reserved for
+// startup errors, see fatalf
+const (
+	// the peers of some domain could not be listed or tested, but the thresholds are met
+	domainErrorStatus = 2
+	// the thresholds are not met
+	thresholdStatus = 3
+)
+
+// PeerRecord is a peer, as listed with -listPeers.
+type PeerRecord struct {
+	Domain  string
+	PeerID  peer.ID `json:",omitempty"`
+	Nick    string
+	NATType string `json:",omitempty"`
+	Addrs   []string
+}
+
+// TestRecord is the outcome of an attempt to connect to a peer with -eagerTest. When the peers
+// of a domain cannot be listed, the domain has a single record without a peer and the server
+// error class.
+type TestRecord struct {
+	PeerRecord
+	Success   bool
+	ElapsedMs int64
+	// ErrorClass is one of server, bootstrap, initial-connection, no-direct-connection, timeout
+	// or other
+	ErrorClass string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+func newTestRecord(domain string, ci *ClientInfo, elapsed time.Duration, err error) *TestRecord {
+	r := &TestRecord{
+		Success:    err == nil,
+		ElapsedMs:  elapsed.Milliseconds(),
+		ErrorClass: classifyConnectError(err),
+	}
+	if ci != nil {
+		r.PeerRecord = newPeerRecord(domain, ci)
+	} else {
+		r.Domain = domain
+		r.ErrorClass = ErrorClassServer
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func newPeerRecord(domain string, ci *ClientInfo) PeerRecord {
+	r := PeerRecord{
+		Domain:  domain,
+		PeerID:  ci.Info.ID,
+		Nick:    ci.Nick,
+		NATType: ci.NATType,
+		Addrs:   make([]string, 0, len(ci.Info.Addrs)),
+	}
+	for _, a := range ci.Info.Addrs {
+		r.Addrs = append(r.Addrs, a.String())
+	}
+	return r
+}
+
+// listPeersCommand lists the peers of all domains and returns the number of peers; domains whose
+// peers cannot be listed are reported on stderr and clear ok.
+func listPeersCommand(clients []*Client, format string) (count int, ok bool) {
+	ok = true
+	records := []*PeerRecord{}
+	for _, c := range clients {
+		peers, err := c.ListPeers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error retrieving peers: %s\n", c.Domain(), err)
+			ok = false
+			continue
+		}
+
+		for _, ci := range peers {
+			r := newPeerRecord(c.Domain(), ci)
+			records = append(records, &r)
+		}
+	}
+
+	header := []string{"Domain", "Peer", "Nick", "NAT", "Addrs"}
+	rows := make([][]string, 0, len(records))
+	for _, r := range records {
+		rows = append(rows, []string{r.Domain, r.PeerID.Pretty(), r.Nick, r.NATType, strings.Join(r.Addrs, " ")})
+	}
+
+	if err := writeOutput(os.Stdout, format, records, header, rows); err != nil {
+		fatalf("error writing peers: %s", err)
+	}
+
+	return len(records), ok
+}
+
+// eagerTestCommand tries to connect to the peers of all domains and returns the test results;
+// domains whose peers cannot be listed or tested are reported on stderr, recorded as failures
+// and clear ok.
+func eagerTestCommand(clients []*Client, format string) (records []*TestRecord, ok bool) {
+	ok = true
+	records = []*TestRecord{}
+	for _, c := range clients {
+		domain := c.Domain()
+		peers, err := c.ListPeers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error retrieving peers: %s\n", domain, err)
+			records = append(records, newTestRecord(domain, nil, 0, err))
+			ok = false
+			continue
+		}
+
+		tested := make(map[peer.ID]struct{})
+		err = c.ConnectPeers(context.Background(), peers, func(ci *ClientInfo, elapsed time.Duration, err error) {
+			records = append(records, newTestRecord(domain, ci, elapsed, err))
+			tested[ci.Info.ID] = struct{}{}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error connecting to peers: %s\n", domain, err)
+			ok = false
+
+			// the peers we could not try, e.g. because of a bootstrap failure, count as failed
+			for _, ci := range peers {
+				if _, done := tested[ci.Info.ID]; !done {
+					records = append(records, newTestRecord(domain, ci, 0, err))
+				}
+			}
+		}
+	}
+
+	header := []string{"Domain", "Peer", "Nick", "NAT", "Result", "ElapsedMs", "ErrorClass", "Error", "Addrs"}
+	rows := make([][]string, 0, len(records))
+	for _, r := range records {
+		result := "OK"
+		if !r.Success {
+			result = "FAILED"
+		}
+		rows = append(rows, []string{
+			r.Domain,
+			r.PeerID.Pretty(),
+			r.Nick,
+			r.NATType,
+			result,
+			strconv.FormatInt(r.ElapsedMs, 10),
+			r.ErrorClass,
+			r.Error,
+			strings.Join(r.Addrs, " "),
+		})
+	}
+
+	if err := writeOutput(os.Stdout, format, records, header, rows); err != nil {
+		fatalf("error writing test results: %s", err)
+	}
+
+	return records, ok
+}
+
+// checkThresholds checks the number of peers and the success rate of the tests against the
+// minimums, reporting unmet thresholds on stderr.
+func checkThresholds(peers int, tests []*TestRecord, minPeers int, minSuccessRate float64) bool {
+	ok := true
+	if peers < minPeers {
+		fmt.Fprintf(os.Stderr, "found %d peers; expected at least %d\n", peers, minPeers)
+		ok = false
+	}
+
+	if tests != nil && minSuccessRate > 0 {
+		successes := 0
+		for _, r := range tests {
+			if r.Success {
+				successes++
+			}
+		}
+
+		rate := 0.0
+		if len(tests) > 0 {
+			rate = float64(successes) / float64(len(tests))
+		}
+		if rate < minSuccessRate {
+			fmt.Fprintf(os.Stderr, "success rate %.2f is below %.2f\n", rate, minSuccessRate)
+			ok = false
+		}
+	}
+
+	return ok
+}
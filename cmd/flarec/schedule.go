@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/vyzo/libp2p-flare-test/util"
+)
+
+// ScheduleConfig is the schedule of the background test rounds.
+type ScheduleConfig struct {
+	// InitialDelay is the delay before the first round, plus a random InitialJitter;
+	// default to 15m and 30m, negative for no delay.
+	InitialDelay  util.Duration
+	InitialJitter util.Duration
+	// Tiers are the intervals between rounds by number of peers; the tier with the highest
+	// MinPeers not exceeding the number of peers applies. Defaults to 30m+1h for up to 10 peers,
+	// 1h+2h for up to 25 peers and 2h+4h for more.
+	Tiers []*ScheduleTier
+	// RetryInterval is the initial interval between retries when the server fails, doubling
+	// with each failure up to MaxRetryInterval; default to 1m and 30m.
+	RetryInterval    util.Duration
+	MaxRetryInterval util.Duration
+	// QuietHours is a daily local time range without rounds, e.g. "22:00-07:00".
+	QuietHours string
+
+	quietStart, quietEnd int // minutes since midnight
+}
+
+// ScheduleTier is the interval between rounds, plus a random Jitter, when we have at least
+// MinPeers peers.
+type ScheduleTier struct {
+	MinPeers int
+	Interval util.Duration
+	Jitter   util.Duration
+}
+
+const (
+	DefaultInitialDelay     = 15 * time.Minute
+	DefaultInitialJitter    = 30 * time.Minute
+	DefaultRetryInterval    = time.Minute
+	DefaultMaxRetryInterval = 30 * time.Minute
+)
+
+// DefaultScheduleTiers returns the default round interval tiers.
+func DefaultScheduleTiers() []*ScheduleTier {
+	return []*ScheduleTier{
+		{MinPeers: 0, Interval: util.Duration(30 * time.Minute), Jitter: util.Duration(time.Hour)},
+		{MinPeers: 11, Interval: util.Duration(time.Hour), Jitter: util.Duration(2 * time.Hour)},
+		{MinPeers: 26, Interval: util.Duration(2 * time.Hour), Jitter: util.Duration(4 * time.Hour)},
+	}
+}
+
+func (s *ScheduleConfig) validate() error {
+	if s.InitialDelay == 0 {
+		s.InitialDelay = util.Duration(DefaultInitialDelay)
+	}
+	if s.InitialJitter == 0 {
+		s.InitialJitter = util.Duration(DefaultInitialJitter)
+	}
+	if s.RetryInterval == 0 {
+		s.RetryInterval = util.Duration(DefaultRetryInterval)
+	}
+	if s.MaxRetryInterval == 0 {
+		s.MaxRetryInterval = util.Duration(DefaultMaxRetryInterval)
+	}
+	if len(s.Tiers) == 0 {
+		s.Tiers = DefaultScheduleTiers()
+	}
+
+	if s.RetryInterval < 0 || s.MaxRetryInterval < s.RetryInterval {
+		return fmt.Errorf("Schedule.MaxRetryInterval must not be lower than Schedule.RetryInterval")
+	}
+
+	for i, tier := range s.Tiers {
+		if tier == nil || tier.Interval <= 0 {
+			return fmt.Errorf("Schedule.Tiers[%d].Interval must be positive", i)
+		}
+		if tier.MinPeers < 0 || tier.Jitter < 0 {
+			return fmt.Errorf("Schedule.Tiers[%d]: MinPeers and Jitter must not be negative", i)
+		}
+	}
+
+	if s.QuietHours != "" {
+		var h1, m1, h2, m2 int
+		_, err := fmt.Sscanf(s.QuietHours, "%d:%d-%d:%d", &h1, &m1, &h2, &m2)
+		if err != nil || h1 > 23 || h2 > 23 || m1 > 59 || m2 > 59 || h1 < 0 || h2 < 0 || m1 < 0 || m2 < 0 {
+			return fmt.Errorf("Schedule.QuietHours: malformed time range %q; expected HH:MM-HH:MM", s.QuietHours)
+		}
+		s.quietStart = h1*60 + m1
+		s.quietEnd = h2*60 + m2
+	}
+
+	return nil
+}
+
+// FirstRound returns the delay before the first round.
+func (s *ScheduleConfig) FirstRound() time.Duration {
+	if s.InitialDelay < 0 {
+		return 0
+	}
+	return jitter(time.Duration(s.InitialDelay), time.Duration(s.InitialJitter))
+}
+
+// NextRound returns the interval until the next round, given the number of peers.
+func (s *ScheduleConfig) NextRound(peers int) time.Duration {
+	var tier *ScheduleTier
+	for _, t := range s.Tiers {
+		if t.MinPeers <= peers && (tier == nil || t.MinPeers > tier.MinPeers) {
+			tier = t
+		}
+	}
+	if tier == nil {
+		// all tiers require more peers; use the lowest
+		for _, t := range s.Tiers {
+			if tier == nil || t.MinPeers < tier.MinPeers {
+				tier = t
+			}
+		}
+	}
+
+	return jitter(time.Duration(tier.Interval), time.Duration(tier.Jitter))
+}
+
+// Retry returns the interval before retrying after the given number of consecutive failures.
+func (s *ScheduleConfig) Retry(failures int) time.Duration {
+	d := time.Duration(s.RetryInterval)
+	for i := 1; i < failures && d < time.Duration(s.MaxRetryInterval); i++ {
+		d *= 2
+	}
+	if d > time.Duration(s.MaxRetryInterval) {
+		d = time.Duration(s.MaxRetryInterval)
+	}
+	return d
+}
+
+// QuietUntil returns the end of the quiet hours if t falls within them, or the zero time.
+func (s *ScheduleConfig) QuietUntil(t time.Time) time.Time {
+	if s.QuietHours == "" || s.quietStart == s.quietEnd {
+		return time.Time{}
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	var quiet bool
+	if s.quietStart < s.quietEnd {
+		quiet = now >= s.quietStart && now < s.quietEnd
+	} else {
+		// the quiet hours span midnight
+		quiet = now >= s.quietStart || now < s.quietEnd
+	}
+	if !quiet {
+		return time.Time{}
+	}
+
+	end := time.Date(t.Year(), t.Month(), t.Day(), s.quietEnd/60, s.quietEnd%60, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+func jitter(d, j time.Duration) time.Duration {
+	if j <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(int64(j)))
+}
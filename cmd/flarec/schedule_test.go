@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vyzo/libp2p-flare-test/util"
+)
+
+func newTestSchedule(t *testing.T, s *ScheduleConfig) *ScheduleConfig {
+	t.Helper()
+
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestScheduleQuietUntil(t *testing.T) {
+	at := func(day, hour, min, sec int) time.Time {
+		return time.Date(2021, 3, day, hour, min, sec, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name   string
+		quiet  string
+		t      time.Time
+		expect time.Time
+	}{
+		{"no quiet hours", "", at(4, 23, 0, 0), time.Time{}},
+		{"empty range", "22:00-22:00", at(4, 22, 0, 0), time.Time{}},
+
+		{"same day before", "01:00-05:30", at(4, 0, 59, 59), time.Time{}},
+		{"same day start", "01:00-05:30", at(4, 1, 0, 0), at(4, 5, 30, 0)},
+		{"same day within", "01:00-05:30", at(4, 5, 29, 59), at(4, 5, 30, 0)},
+		{"same day end", "01:00-05:30", at(4, 5, 30, 0), time.Time{}},
+
+		{"midnight before", "22:00-07:00", at(4, 21, 59, 0), time.Time{}},
+		{"midnight start", "22:00-07:00", at(4, 22, 0, 0), at(5, 7, 0, 0)},
+		{"midnight before midnight", "22:00-07:00", at(4, 23, 59, 59), at(5, 7, 0, 0)},
+		{"midnight at midnight", "22:00-07:00", at(5, 0, 0, 0), at(5, 7, 0, 0)},
+		{"midnight after midnight", "22:00-07:00", at(5, 6, 59, 30), at(5, 7, 0, 0)},
+		{"midnight end", "22:00-07:00", at(5, 7, 0, 0), time.Time{}},
+		{"midnight month end", "22:00-07:00", at(31, 23, 0, 0), time.Date(2021, 4, 1, 7, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newTestSchedule(t, &ScheduleConfig{QuietHours: c.quiet})
+			if end := s.QuietUntil(c.t); !end.Equal(c.expect) {
+				t.Fatalf("expected %s, got %s", c.expect, end)
+			}
+		})
+	}
+}
+
+func TestScheduleQuietHoursMalformed(t *testing.T) {
+	for _, quiet := range []string{"22:00", "24:00-07:00", "22:60-07:00", "22:00-7pm", "-1:00-07:00"} {
+		s := &ScheduleConfig{QuietHours: quiet}
+		if err := s.validate(); err == nil {
+			t.Errorf("expected error for quiet hours %q", quiet)
+		}
+	}
+}
+
+func TestScheduleRetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval time.Duration
+		max      time.Duration
+		expect   []time.Duration // by number of failures, starting at 1
+	}{
+		{
+			name:   "defaults",
+			expect: []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 30 * time.Minute, 30 * time.Minute},
+		},
+		{
+			name:     "cap at power of two",
+			interval: time.Second,
+			max:      4 * time.Second,
+			expect:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second},
+		},
+		{
+			name:     "cap between powers of two",
+			interval: time.Second,
+			max:      3 * time.Second,
+			expect:   []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:     "no backoff",
+			interval: time.Minute,
+			max:      time.Minute,
+			expect:   []time.Duration{time.Minute, time.Minute, time.Minute},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newTestSchedule(t, &ScheduleConfig{RetryInterval: util.Duration(c.interval), MaxRetryInterval: util.Duration(c.max)})
+			for i, expect := range c.expect {
+				if d := s.Retry(i + 1); d != expect {
+					t.Fatalf("after %d failures: expected %s, got %s", i+1, expect, d)
+				}
+			}
+
+			// the interval stays capped, without overflowing, after many failures
+			if d := s.Retry(1000); d != time.Duration(s.MaxRetryInterval) {
+				t.Fatalf("after 1000 failures: expected %s, got %s", time.Duration(s.MaxRetryInterval), d)
+			}
+		})
+	}
+}
+
+func TestScheduleRetryInvalid(t *testing.T) {
+	s := &ScheduleConfig{RetryInterval: util.Duration(time.Hour), MaxRetryInterval: util.Duration(time.Minute)}
+	if err := s.validate(); err == nil {
+		t.Fatal("expected error for a maximum retry interval below the retry interval")
+	}
+}
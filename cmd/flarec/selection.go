@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Peer selection strategies
+const (
+	SelectAll        = "all"
+	SelectRandom     = "random"
+	SelectNATDiverse = "nat-diverse"
+)
+
+const (
+	DefaultPeerSelection    = SelectRandom
+	DefaultPeerCooldown     = 6 * time.Hour
+	DefaultMaxPeersPerRound = 25
+)
+
+// SelectionStrategy orders the candidate peers of a test round by preference.
+type SelectionStrategy func(peers []*ClientInfo) []*ClientInfo
+
+// SelectionStrategies is the registry of peer selection strategies, keyed by name.
+var SelectionStrategies = map[string]SelectionStrategy{
+	SelectAll:        selectAll,
+	SelectRandom:     selectRandom,
+	SelectNATDiverse: selectNATDiverse,
+}
+
+// PeerSelector selects the peers to try in each round of the background test: peers we
+// tried within the cooldown period are skipped, untested peers are tried first, and the
+// remaining peers are ordered by the selection strategy and capped to the maximum number
+// of peers per round.
+type PeerSelector struct {
+	mx          sync.Mutex
+	lastAttempt map[peer.ID]time.Time
+}
+
+// NewPeerSelector creates a peer selector for a domain, seeded with the previous attempts
+// recorded in the history.
+func NewPeerSelector(domain string, history *History) *PeerSelector {
+	s := &PeerSelector{lastAttempt: make(map[peer.ID]time.Time)}
+
+	if history == nil {
+		return s
+	}
+
+	attempts, err := history.Attempts(domain, "")
+	if err != nil {
+		log.Debugf("no history for peer selection: %s", err)
+		return s
+	}
+
+	for _, a := range attempts {
+		if a.Time.After(s.lastAttempt[a.RemotePeer]) {
+			s.lastAttempt[a.RemotePeer] = a.Time
+		}
+	}
+
+	return s
+}
+
+// Select selects the peers to try in this round and marks them as tried.
+func (s *PeerSelector) Select(cfg *Config, peers []*ClientInfo) []*ClientInfo {
+	strategy, ok := SelectionStrategies[cfg.PeerSelection]
+	if !ok {
+		strategy = SelectionStrategies[DefaultPeerSelection]
+	}
+	cooldown := cfg.PeerCooldown.Or(DefaultPeerCooldown)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+
+	var untested, tested []*ClientInfo
+	for _, ci := range peers {
+		last, ok := s.lastAttempt[ci.Info.ID]
+		switch {
+		case !ok:
+			untested = append(untested, ci)
+		case now.Sub(last) >= cooldown:
+			tested = append(tested, ci)
+		}
+	}
+
+	result := append(strategy(untested), strategy(tested)...)
+	if cfg.MaxPeersPerRound > 0 && len(result) > cfg.MaxPeersPerRound {
+		result = result[:cfg.MaxPeersPerRound]
+	}
+
+	for _, ci := range result {
+		s.lastAttempt[ci.Info.ID] = now
+	}
+
+	return result
+}
+
+// selectAll tries the peers in the order returned by the server.
+func selectAll(peers []*ClientInfo) []*ClientInfo {
+	return peers
+}
+
+// selectRandom tries the peers in random order, so that capped rounds sample the peers uniformly.
+func selectRandom(peers []*ClientInfo) []*ClientInfo {
+	result := make([]*ClientInfo, len(peers))
+	copy(result, peers)
+	rand.Shuffle(len(result), func(i, j int) {
+		result[i], result[j] = result[j], result[i]
+	})
+	return result
+}
+
+// selectNATDiverse interleaves random peers of each NAT type, so that capped rounds cover all
+// NAT types.
+func selectNATDiverse(peers []*ClientInfo) []*ClientInfo {
+	buckets := make(map[string][]*ClientInfo)
+	var natTypes []string
+	for _, ci := range selectRandom(peers) {
+		if _, ok := buckets[ci.NATType]; !ok {
+			natTypes = append(natTypes, ci.NATType)
+		}
+		buckets[ci.NATType] = append(buckets[ci.NATType], ci)
+	}
+
+	rand.Shuffle(len(natTypes), func(i, j int) {
+		natTypes[i], natTypes[j] = natTypes[j], natTypes[i]
+	})
+
+	result := make([]*ClientInfo, 0, len(peers))
+	for len(result) < len(peers) {
+		for _, natType := range natTypes {
+			bucket := buckets[natType]
+			if len(bucket) == 0 {
+				continue
+			}
+			result = append(result, bucket[0])
+			buckets[natType] = bucket[1:]
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	pb "github.com/vyzo/libp2p-flare-test/pb"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-msgio/protoio"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	serverRetryInterval = time.Minute
+	serverMaxBackoff    = 30 * time.Minute
+)
+
+// serverState tracks the health of a presence server and whether it has our current announcement.
+type serverState struct {
+	info      *peer.AddrInfo
+	failures  int
+	backoff   time.Time
+	announced bool
+}
+
+// openServerStream opens an authenticated stream to the first healthy server, in order of preference.
+func (c *Client) openServerStream() (network.Stream, error) {
+	var lastErr error
+	for _, srv := range c.serverCandidates() {
+		s, err := c.connectToServer(srv.info)
+		if err != nil {
+			log.Warnf("error connecting to server %s: %s", srv.info.ID, err)
+			c.serverFailed(srv)
+			lastErr = err
+			continue
+		}
+
+		c.serverOK(srv)
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("no reachable server: %w", lastErr)
+}
+
+// serverCandidates returns the servers not in backoff, in order of preference; if all servers are in
+// backoff, then all servers are returned.
+func (c *Client) serverCandidates() []*serverState {
+	c.serverMx.Lock()
+	defer c.serverMx.Unlock()
+
+	var result []*serverState
+	now := time.Now()
+	for _, srv := range c.servers {
+		if now.Before(srv.backoff) {
+			continue
+		}
+		result = append(result, srv)
+	}
+
+	if len(result) == 0 {
+		result = append(result, c.servers...)
+	}
+
+	return result
+}
+
+func (c *Client) serverFailed(srv *serverState) {
+	c.serverMx.Lock()
+	defer c.serverMx.Unlock()
+
+	srv.failures++
+	backoff := serverRetryInterval << uint(srv.failures-1)
+	if backoff > serverMaxBackoff || backoff <= 0 {
+		backoff = serverMaxBackoff
+	}
+	srv.backoff = time.Now().Add(backoff)
+}
+
+func (c *Client) serverOK(srv *serverState) {
+	c.serverMx.Lock()
+	defer c.serverMx.Unlock()
+
+	srv.failures = 0
+	srv.backoff = time.Time{}
+}
+
+// announce announces our presence with the given addresses to all reachable servers, retrying
+// until at least one server has accepted it; servers that missed the announcement get it
+// in the background once they become reachable.
+func (c *Client) announce(addrs []ma.Multiaddr) {
+	c.serverMx.Lock()
+	c.announceAddrs = addrs
+	for _, srv := range c.servers {
+		srv.announced = false
+	}
+	start := !c.announcing
+	c.announcing = true
+	c.serverMx.Unlock()
+
+	for c.announcePending() == 0 {
+		log.Warnf("could not announce presence to any server; will retry in 1min")
+		time.Sleep(serverRetryInterval)
+	}
+
+	if start {
+		go c.maintainAnnouncements()
+	}
+}
+
+// announcePending announces our presence to the servers that don't have our current announcement
+// and returns the number of servers that do.
+func (c *Client) announcePending() int {
+	c.announceMx.Lock()
+	defer c.announceMx.Unlock()
+
+	c.serverMx.Lock()
+	addrs := c.announceAddrs
+	c.serverMx.Unlock()
+
+	for _, srv := range c.serverCandidates() {
+		c.serverMx.Lock()
+		announced := srv.announced
+		c.serverMx.Unlock()
+
+		if announced {
+			continue
+		}
+
+		err := c.announceTo(srv.info, addrs)
+		if err != nil {
+			log.Warnf("error announcing presence to server %s: %s", srv.info.ID, err)
+			c.serverFailed(srv)
+			continue
+		}
+
+		c.serverOK(srv)
+		c.host.ConnManager().Protect(srv.info.ID, "flare")
+
+		c.serverMx.Lock()
+		srv.announced = true
+		c.serverMx.Unlock()
+	}
+
+	c.serverMx.Lock()
+	defer c.serverMx.Unlock()
+
+	count := 0
+	for _, srv := range c.servers {
+		if srv.announced {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (c *Client) announceTo(server *peer.AddrInfo, addrs []ma.Multiaddr) error {
+	s, err := c.connectToServer(server)
+	if err != nil {
+		return err
+	}
+
+	c.serverMx.Lock()
+	natType := c.natType
+	c.serverMx.Unlock()
+
+	var msg pb.FlareMessage
+	wr := protoio.NewDelimitedWriter(s)
+
+	msg.Type = pb.FlareMessage_ANNOUNCE.Enum()
+	msg.Announce = &pb.Announce{
+		Domain:   &c.domain.Name,
+		PeerInfo: makePeerInfo(c.nick, natType, peer.AddrInfo{ID: c.host.ID(), Addrs: addrs}),
+	}
+
+	if err := wr.WriteMsg(&msg); err != nil {
+		s.Reset()
+		return fmt.Errorf("error writing announcement: %w", err)
+	}
+
+	return s.Close()
+}
+
+// maintainAnnouncements periodically announces our presence to servers that missed our
+// current announcement, either because they were unreachable or because they dropped us.
+func (c *Client) maintainAnnouncements() {
+	for {
+		time.Sleep(serverRetryInterval)
+		c.announcePending()
+	}
+}
+
+// serverDisconnected notes that a server has dropped us, together with our announcement.
+func (c *Client) serverDisconnected(p peer.ID) {
+	c.serverMx.Lock()
+	defer c.serverMx.Unlock()
+
+	for _, srv := range c.servers {
+		if srv.info.ID == p && srv.announced {
+			log.Debugf("disconnected from server %s", p)
+			srv.announced = false
+		}
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"runtime"
+	"sync"
 	"time"
 
+	"github.com/vyzo/libp2p-flare-test/events"
+
 	"github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
@@ -15,68 +18,91 @@ import (
 )
 
 type Tracer struct {
-	logz   *logzio.LogzioSender
+	mx    sync.Mutex
+	logz  *logzio.LogzioSender
+	token string
+
 	id     peer.ID
-	domain string
+	domain *Domain
 	nick   string
-}
-
-var _ holepunch.EventTracer = (*Tracer)(nil)
 
-type Event struct {
-	Time   int64 // UNIX time
-	Domain string
-	Peer   peer.ID
-	Nick   string
-	Type   string
-	Evt    interface{}
+	// set when a server reports that our version is below its minimum version
+	outdated bool
+	// set to print hole punching traces
+	verbose bool
 }
 
-const (
-	AnnounceEvtT = "announce"
-	ConnectEvtT  = "connect"
-	TraceEvtT    = "trace"
-)
+var _ holepunch.EventTracer = (*Tracer)(nil)
 
-type AnnounceEvt struct {
-	OSType  string
-	NATType string
-}
+func NewTracer(cfg *Config, id peer.ID, domain *Domain, nick string) (*Tracer, error) {
+	logz, err := newLogzSender(cfg.LogzioToken)
+	if err != nil {
+		return nil, err
+	}
 
-type ConnectEvt struct {
-	RemotePeer peer.ID
-	RemoteNick string
-	Success    bool
-	Error      string `json:",omitempty"`
+	return &Tracer{
+		logz:   logz,
+		token:  cfg.LogzioToken,
+		id:     id,
+		domain: domain,
+		nick:   nick,
+	}, nil
 }
 
-func NewTracer(cfg *Config, id peer.ID, domain, nick string) (*Tracer, error) {
+func newLogzSender(token string) (*logzio.LogzioSender, error) {
 	dir, err := ioutil.TempDir("", "flarec.*")
 	if err != nil {
 		return nil, err
 	}
 
-	logz, err := logzio.New(cfg.LogzioToken, logzio.SetTempDirectory(dir))
+	return logzio.New(token, logzio.SetTempDirectory(dir))
+}
+
+// SetToken switches event shipping to a new logz.io token.
+func (t *Tracer) SetToken(token string) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if token == t.token {
+		return nil
+	}
+
+	logz, err := newLogzSender(token)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &Tracer{
-		logz:   logz,
-		id:     id,
-		domain: domain,
-		nick:   nick,
-	}, nil
+	t.logz.Stop()
+	t.logz = logz
+	t.token = token
+
+	return nil
+}
+
+// SetOutdated flags subsequent events as coming from an outdated client.
+func (t *Tracer) SetOutdated(outdated bool) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.outdated = outdated
 }
 
 func (t *Tracer) send(et string, e interface{}) {
-	evt := &Event{
-		Time:   time.Now().Unix(),
-		Domain: t.domain,
-		Peer:   t.id,
-		Nick:   t.nick,
-		Type:   et,
-		Evt:    e,
+	t.mx.Lock()
+	outdated := t.outdated
+	t.mx.Unlock()
+
+	evt := &events.Event{
+		Schema:    events.SchemaVersion,
+		Time:      time.Now().Unix(),
+		Domain:    t.domain.Name,
+		IPVersion: t.domain.IPVersion,
+		Peer:      t.id,
+		Nick:      t.nick,
+		Type:      et,
+		Version:   ClientVersion,
+		Outdated:  outdated,
+		Evt:       e,
 	}
 
 	data, err := json.Marshal(evt)
@@ -85,36 +111,90 @@ func (t *Tracer) send(et string, e interface{}) {
 		return
 	}
 
-	err = t.logz.Send(data)
+	t.mx.Lock()
+	logz := t.logz
+	t.mx.Unlock()
+
+	err = logz.Send(data)
 	if err != nil {
 		log.Errorf("error shipping event to logz.io: %s", err)
 	}
 }
 
-func (t *Tracer) Announce(natType string) {
-	t.send(AnnounceEvtT, &AnnounceEvt{
+func (t *Tracer) Announce(natType string, behavior *NATBehavior) {
+	evt := &events.AnnounceEvt{
 		OSType:  fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
 		NATType: natType,
-	})
+	}
+	if behavior != nil {
+		evt.NATBehavior = (*events.NATBehavior)(behavior)
+	}
+	t.send(events.AnnounceEvtT, evt)
 }
 
-func (t *Tracer) Connect(ci *ClientInfo, err error) {
-	evt := &ConnectEvt{
+func (t *Tracer) Connect(ci *ClientInfo, network string, err error) {
+	evt := &events.ConnectEvt{
 		RemotePeer: ci.Info.ID,
 		RemoteNick: ci.Nick,
+		Network:    network,
 		Success:    err == nil,
 	}
 	if err != nil {
 		evt.Error = err.Error()
 	}
-	t.send(ConnectEvtT, evt)
+	t.send(events.ConnectEvtT, evt)
+}
+
+func (t *Tracer) Disconnect(ci *ClientInfo, lifetime, idle time.Duration, err error) {
+	t.send(events.DisconnEvtT, &events.DisconnEvt{
+		RemotePeer: ci.Info.ID,
+		RemoteNick: ci.Nick,
+		Lifetime:   lifetime.Milliseconds(),
+		IdleTime:   idle.Milliseconds(),
+		Error:      err.Error(),
+	})
+}
+
+// Reservation traces a relay reservation; err is nil when the reservation was acquired,
+// otherwise it is the reason for losing it.
+func (t *Tracer) Reservation(relay peer.ID, expiration time.Time, err error) {
+	evt := &events.ReserveEvt{
+		Relay:    relay,
+		Reserved: err == nil,
+	}
+	if err != nil {
+		evt.Error = err.Error()
+	} else {
+		evt.Expiration = expiration.Unix()
+	}
+	t.send(events.ReserveEvtT, evt)
+}
+
+// SetVerbose enables printing hole punching traces to stdout.
+func (t *Tracer) SetVerbose(verbose bool) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.verbose = verbose
 }
 
 func (t *Tracer) Trace(evt *holepunch.Event) {
-	t.send(TraceEvtT, evt)
+	t.mx.Lock()
+	verbose := t.verbose
+	t.mx.Unlock()
+
+	if verbose {
+		data, _ := json.Marshal(evt.Evt)
+		fmt.Printf("\t%s %s %s: %s\n", time.Unix(0, evt.Timestamp).Format("15:04:05.000"), t.domain.Name, evt.Type, data)
+	}
+
+	t.send(events.TraceEvtT, evt)
 }
 
 func (t *Tracer) Close() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
 	t.logz.Stop()
 	return nil
 }
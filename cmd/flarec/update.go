@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	pb "github.com/vyzo/libp2p-flare-test/pb"
+	"github.com/vyzo/libp2p-flare-test/proto"
+	"github.com/vyzo/libp2p-flare-test/util"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-msgio/protoio"
+)
+
+const maxConfigSize = 1 << 16
+
+// ConfigUpdater receives configuration updates pushed by the presence servers, applies them
+// to the running clients, and persists them next to the original configuration, so that they
+// survive restarts.
+type ConfigUpdater struct {
+	sync.Mutex
+
+	path     string
+	adminKey crypto.PubKey
+	enabled  func(name string) bool
+	cfg      *Config
+	clients  []*Client
+}
+
+func NewConfigUpdater(cfgPath string, adminKey crypto.PubKey, enabled func(name string) bool, cfg *Config) *ConfigUpdater {
+	return &ConfigUpdater{
+		path:     cfgPath + ".update",
+		adminKey: adminKey,
+		enabled:  enabled,
+		cfg:      cfg,
+	}
+}
+
+// LoadPersisted loads the last persisted update, if it is newer than the current configuration;
+// it must be called before adding clients. Updates are only used with a pinned admin key.
+func (u *ConfigUpdater) LoadPersisted() error {
+	data, err := ioutil.ReadFile(u.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if u.adminKey == nil {
+		log.Warnf("ignoring configuration update in %s: no admin key pinned", u.path)
+		return nil
+	}
+
+	cfg, err := u.parse(data)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %w", u.path, err)
+	}
+
+	if cfg.ConfigVersion <= u.cfg.ConfigVersion {
+		return nil
+	}
+
+	log.Infof("using updated configuration version %d", cfg.ConfigVersion)
+	*u.cfg = *cfg
+	return nil
+}
+
+// AddClient starts accepting updates from the servers of a client and applying updates to it.
+func (u *ConfigUpdater) AddClient(c *Client) {
+	u.Lock()
+	u.clients = append(u.clients, c)
+	u.Unlock()
+
+	c.host.SetStreamHandler(proto.ConfigProtoID, func(s network.Stream) {
+		u.handleStream(c, s)
+	})
+}
+
+func (u *ConfigUpdater) handleStream(c *Client, s network.Stream) {
+	defer s.Close()
+
+	p := s.Conn().RemotePeer()
+	if !c.isServer(p) {
+		log.Warnf("ignoring config update from %s: not a server", p)
+		s.Reset()
+		return
+	}
+
+	if u.adminKey == nil {
+		log.Warnf("ignoring config update from %s: no admin key pinned", p)
+		s.Reset()
+		return
+	}
+
+	var msg pb.ConfigUpdate
+	rd := protoio.NewDelimitedReader(s, maxConfigSize)
+	if err := rd.ReadMsg(&msg); err != nil {
+		log.Warnf("error reading config update from %s: %s", p, err)
+		s.Reset()
+		return
+	}
+
+	if err := u.update(msg.GetConfig()); err != nil {
+		log.Warnf("error applying config update from %s: %s", p, err)
+	}
+}
+
+// update applies and persists a configuration update, if it is newer than our configuration.
+func (u *ConfigUpdater) update(data []byte) error {
+	cfg, err := u.parse(data)
+	if err != nil {
+		return err
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	if cfg.ConfigVersion <= u.cfg.ConfigVersion {
+		log.Debugf("ignoring config update version %d; we have version %d", cfg.ConfigVersion, u.cfg.ConfigVersion)
+		return nil
+	}
+
+	log.Infof("updating configuration to version %d", cfg.ConfigVersion)
+
+	tmp := u.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error persisting update: %w", err)
+	}
+	if err := os.Rename(tmp, u.path); err != nil {
+		return fmt.Errorf("error persisting update: %w", err)
+	}
+
+	u.cfg = cfg
+	for _, c := range u.clients {
+		if err := c.applyConfig(cfg); err != nil {
+			log.Warnf("error applying configuration to %s client: %s", c.Domain(), err)
+		}
+	}
+
+	return nil
+}
+
+// parse verifies and parses a configuration update, applying environment overrides and defaults;
+// updates must be signed by the pinned admin key.
+func (u *ConfigUpdater) parse(data []byte) (*Config, error) {
+	if u.adminKey == nil {
+		return nil, fmt.Errorf("configuration updates require a pinned admin key")
+	}
+
+	data, err := util.OpenSignedConfig(data, u.adminKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := new(Config)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("error parsing configuration: %w", err)
+	}
+
+	if err := util.LoadEnvSigned(ConfigEnvPrefix, cfg, true); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(u.enabled); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// applyConfig applies a configuration update to a running client; the relay and server
+// addresses, the monitoring parameters and the tracer settings take effect immediately.
+func (c *Client) applyConfig(cfg *Config) error {
+	var dc *DomainConfig
+	for _, d := range cfg.GetDomains() {
+		if d.Name == c.domain.Name {
+			dc = d
+			break
+		}
+	}
+	if dc == nil {
+		return fmt.Errorf("domain %s is no longer configured", c.domain.Name)
+	}
+
+	domain, err := NewDomain(dc)
+	if err != nil {
+		return err
+	}
+
+	c.cfgMx.Lock()
+	c.cfg = cfg
+	c.cfgMx.Unlock()
+
+	c.monitor.SetTiming(cfg.MonitorPeriod.Or(DefaultMonitorPeriod), cfg.MonitorInterval.Or(DefaultMonitorInterval))
+
+	if err := c.tracer.SetToken(cfg.LogzioToken); err != nil {
+		log.Warnf("error updating tracer: %s", err)
+	}
+
+	c.updateServers(domain.Servers)
+	c.updateRelays(domain.Relays, domain.RelayCount)
+
+	return nil
+}
+
+// updateServers replaces our servers, keeping the state of the servers that remain.
+func (c *Client) updateServers(servers []*peer.AddrInfo) {
+	c.serverMx.Lock()
+	defer c.serverMx.Unlock()
+
+	old := make(map[peer.ID]*serverState)
+	for _, srv := range c.servers {
+		old[srv.info.ID] = srv
+	}
+
+	var result []*serverState
+	for _, info := range servers {
+		srv, ok := old[info.ID]
+		if ok {
+			delete(old, info.ID)
+			srv.info = info
+		} else {
+			srv = &serverState{info: info}
+		}
+		result = append(result, srv)
+	}
+
+	for p := range old {
+		c.host.ConnManager().Unprotect(p, "flare")
+	}
+
+	c.servers = result
+	c.domain.Servers = servers
+}
+
+// updateRelays replaces our relays, dropping reservations with relays that are no longer configured.
+func (c *Client) updateRelays(relays []*peer.AddrInfo, count int) {
+	c.relayMx.Lock()
+	c.domain.Relays = relays
+	c.domain.RelayCount = count
+
+	keep := make(map[peer.ID]struct{})
+	for _, relay := range relays {
+		keep[relay.ID] = struct{}{}
+	}
+
+	var drop []peer.ID
+	for p := range c.reservations {
+		if _, ok := keep[p]; !ok {
+			drop = append(drop, p)
+		}
+	}
+	c.relayMx.Unlock()
+
+	for _, p := range drop {
+		c.dropReservation(p, errRelayRemoved)
+	}
+
+	// wake up the maintenance loop to fill up our reservations and announce the change
+	select {
+	case c.relayUpdate <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) isServer(p peer.ID) bool {
+	c.serverMx.Lock()
+	defer c.serverMx.Unlock()
+
+	for _, srv := range c.servers {
+		if srv.info.ID == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Client) config() *Config {
+	c.cfgMx.Lock()
+	defer c.cfgMx.Unlock()
+
+	return c.cfg
+}
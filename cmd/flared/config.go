@@ -1,9 +1,83 @@
 package main
 
-import ()
+import (
+	"fmt"
+	"time"
+
+	"github.com/vyzo/libp2p-flare-test/util"
+)
+
+// ConfigEnvPrefix is the prefix of environment variables overriding configuration fields.
+const ConfigEnvPrefix = "FLARED"
 
 type Config struct {
-	Secret        string
-	ListenAddrs   []string
-	AnnounceAddrs []string
+	Secret string
+	// PreviousSecrets are secrets that are still accepted until their expiration, so that
+	// the secret can be rotated without locking out clients with the previous secret.
+	PreviousSecrets []*PreviousSecret
+	ListenAddrs     []string
+	AnnounceAddrs   []string
+	// EchoAddrs are the UDP and TCP multiaddrs for the address echo service used for
+	// NAT behavior classification; they must be bound to specific IPs and distinct
+	// from ListenAddrs.
+	EchoAddrs []string
+	// ClientConfig is the path of the client configuration, as signed with sign-config, that is
+	// pushed to clients with an older ConfigVersion.
+	ClientConfig string
+	// MinClientVersion is the minimum client version, e.g. 0.2; older clients are sent an
+	// upgrade notice with UpgradeMessage and flag their reports as outdated. If
+	// RejectOutdatedClients is set, they are also refused service.
+	MinClientVersion      string
+	UpgradeMessage        string
+	RejectOutdatedClients bool
+}
+
+// PreviousSecret is a secret that was rotated out, but is accepted until it expires.
+type PreviousSecret struct {
+	Secret  string
+	Expires time.Time
+}
+
+// Validate checks the configuration.
+func (cfg *Config) Validate() error {
+	if cfg.Secret == "" {
+		return fmt.Errorf("Secret is required")
+	}
+
+	for i, ps := range cfg.PreviousSecrets {
+		if ps == nil || ps.Secret == "" {
+			return fmt.Errorf("PreviousSecrets[%d].Secret is required", i)
+		}
+		if ps.Expires.IsZero() {
+			return fmt.Errorf("PreviousSecrets[%d].Expires is required", i)
+		}
+	}
+
+	if len(cfg.ListenAddrs) == 0 {
+		return fmt.Errorf("ListenAddrs is required")
+	}
+
+	for i, a := range cfg.ListenAddrs {
+		if err := util.CheckAddr(fmt.Sprintf("ListenAddrs[%d]", i), a, false); err != nil {
+			return err
+		}
+	}
+	for i, a := range cfg.AnnounceAddrs {
+		if err := util.CheckAddr(fmt.Sprintf("AnnounceAddrs[%d]", i), a, false); err != nil {
+			return err
+		}
+	}
+	for i, a := range cfg.EchoAddrs {
+		if err := util.CheckAddr(fmt.Sprintf("EchoAddrs[%d]", i), a, false); err != nil {
+			return err
+		}
+	}
+
+	if cfg.MinClientVersion != "" {
+		if _, err := util.ParseVersion(cfg.MinClientVersion); err != nil {
+			return fmt.Errorf("MinClientVersion: %w", err)
+		}
+	}
+
+	return nil
 }
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
 	"io"
+	"net"
 	"sync"
 	"time"
 
 	pb "github.com/vyzo/libp2p-flare-test/pb"
 	"github.com/vyzo/libp2p-flare-test/proto"
+	"github.com/vyzo/libp2p-flare-test/util"
 
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
@@ -14,26 +17,46 @@ import (
 
 	"github.com/libp2p/go-msgio/protoio"
 	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 )
 
 const maxMsgSize = 4096
 
 type Daemon struct {
 	sync.Mutex
-	secret string
-	peers  map[string]map[peer.ID]*ClientInfo
+	host    host.Host
+	secret  string
+	secrets []*PreviousSecret
+	peers   map[string]map[peer.ID]*ClientInfo
+
+	// authenticated clients and their configuration version
+	clients       map[peer.ID]uint64
+	clientConfig  []byte
+	configVersion uint64
+
+	// client version policy
+	minVersion     util.Version
+	upgradeMessage string
+	rejectOutdated bool
 }
 
 type ClientInfo struct {
-	nick string
-	pi   peer.AddrInfo
+	nick    string
+	natType string
+	pi      peer.AddrInfo
+	ip      net.IP // observed public IP at announce time
+	// networkID identifies the public IP in peer lists; see networkID
+	networkID []byte
 }
 
 func NewDaemon(h host.Host, cfg *Config) *Daemon {
 	daemon := &Daemon{
-		secret: cfg.Secret,
-		peers:  make(map[string]map[peer.ID]*ClientInfo),
+		host:    h,
+		peers:   make(map[string]map[peer.ID]*ClientInfo),
+		clients: make(map[peer.ID]uint64),
 	}
+	daemon.SetSecrets(cfg.Secret, cfg.PreviousSecrets)
+	daemon.SetVersionPolicy(cfg)
 	h.SetStreamHandler(proto.ProtoID, daemon.handleStream)
 	h.Network().Notify(&network.NotifyBundle{
 		DisconnectedF: daemon.disconnect,
@@ -50,6 +73,7 @@ func (d *Daemon) disconnect(n network.Network, c network.Conn) {
 	for _, peers := range d.peers {
 		delete(peers, p)
 	}
+	delete(d.clients, p)
 }
 
 func (d *Daemon) handleStream(s network.Stream) {
@@ -58,6 +82,11 @@ func (d *Daemon) handleStream(s network.Stream) {
 	p := s.Conn().RemotePeer()
 	log.Debugf("incoming stream from %s at %s", p, s.Conn().RemoteMultiaddr())
 
+	ip, err := manet.ToIP(s.Conn().RemoteMultiaddr())
+	if err != nil {
+		log.Debugf("error determining IP address for %s: %s", p, err)
+	}
+
 	var msg pb.FlareMessage
 	wr := protoio.NewDelimitedWriter(s)
 	rd := protoio.NewDelimitedReader(s, maxMsgSize)
@@ -90,7 +119,12 @@ func (d *Daemon) handleStream(s network.Stream) {
 		s.Reset()
 		return
 	}
-	proof := proto.Proof(d.secret, salt, authNonce)
+	secrets := d.validSecrets()
+	proof := proto.Proof(secrets[0], salt, authNonce)
+	var proofs [][]byte
+	for _, secret := range secrets[1:] {
+		proofs = append(proofs, proto.Proof(secret, salt, authNonce))
+	}
 	challengeNonce, err := proto.Nonce()
 	if err != nil {
 		log.Warnf("error generating nonce for %s: %s", p, err)
@@ -98,12 +132,17 @@ func (d *Daemon) handleStream(s network.Stream) {
 		return
 	}
 
+	version := d.clientVersion(s, auth.GetClientVersion())
+	upgrade := d.checkVersion(version)
+
 	msg.Reset()
 	msg.Type = pb.FlareMessage_CHALLENGE.Enum()
 	msg.Challenge = &pb.Challenge{
-		Proof: proof,
-		Salt:  salt,
-		Nonce: challengeNonce,
+		Proof:   proof,
+		Salt:    salt,
+		Nonce:   challengeNonce,
+		Proofs:  proofs,
+		Upgrade: upgrade,
 	}
 	if err := wr.WriteMsg(&msg); err != nil {
 		log.Warnf("error writing challenge message to %s: %s", p, err)
@@ -111,6 +150,14 @@ func (d *Daemon) handleStream(s network.Stream) {
 		return
 	}
 
+	if upgrade != nil {
+		if upgrade.GetRejected() {
+			log.Infof("rejecting outdated client %s (%s)", p, version)
+			return
+		}
+		log.Infof("peer %s is an outdated client (%s)", p, version)
+	}
+
 	msg.Reset()
 	if err := rd.ReadMsg(&msg); err != nil {
 		log.Warnf("error reading response message from %s: %s", p, err)
@@ -134,13 +181,14 @@ func (d *Daemon) handleStream(s network.Stream) {
 
 	proof = resp.GetProof()
 	salt = resp.GetSalt()
-	if !proto.Verify(d.secret, salt, challengeNonce, proof) {
+	if !verifyProof(secrets, salt, challengeNonce, proof) {
 		log.Errorf("authentication failure from %s", p)
 		s.Reset()
 		return
 	}
 
 	log.Infof("peer %s successfully authenticated", p)
+	d.authenticated(p, auth.GetConfigVersion())
 
 	// client is authenticated, handle announcements and peer requests
 	for {
@@ -178,6 +226,8 @@ func (d *Daemon) handleStream(s network.Stream) {
 			}
 
 			log.Infof("peer %s announced presence", p)
+			cinfo.ip = ip
+			cinfo.networkID = networkID(ip)
 
 			d.Lock()
 			peers, ok := d.peers[domain]
@@ -185,6 +235,11 @@ func (d *Daemon) handleStream(s network.Stream) {
 				peers = make(map[peer.ID]*ClientInfo)
 				d.peers[domain] = peers
 			}
+			for _, other := range peers {
+				if other.pi.ID != p && sameIP(other.ip, ip) {
+					log.Infof("peer %s has the same public IP as %s", p, other.pi.ID)
+				}
+			}
 			peers[p] = cinfo
 			d.Unlock()
 
@@ -207,6 +262,10 @@ func (d *Daemon) handleStream(s network.Stream) {
 						continue
 					}
 					pi := peerInfoFromClientInfo(info)
+					if sameIP(info.ip, ip) {
+						samePublicIP := true
+						pi.SamePublicIP = &samePublicIP
+					}
 					pis = append(pis, pi)
 				}
 			}
@@ -232,6 +291,7 @@ func (d *Daemon) handleStream(s network.Stream) {
 func clientInfoFromPeerInfo(pi *pb.PeerInfo) (*ClientInfo, error) {
 	result := new(ClientInfo)
 	result.nick = pi.GetNick()
+	result.natType = pi.GetNatType()
 
 	pid, err := peer.IDFromBytes(pi.GetPeerID())
 	if err != nil {
@@ -255,6 +315,12 @@ func peerInfoFromClientInfo(info *ClientInfo) *pb.PeerInfo {
 		Nick:   &info.nick,
 		PeerID: []byte(info.pi.ID),
 	}
+	if info.natType != "" {
+		result.NatType = &info.natType
+	}
+	if info.networkID != nil {
+		result.NetworkID = info.networkID
+	}
 
 	for _, a := range info.pi.Addrs {
 		result.Addrs = append(result.Addrs, a.Bytes())
@@ -262,3 +328,19 @@ func peerInfoFromClientInfo(info *ClientInfo) *pb.PeerInfo {
 
 	return result
 }
+
+// networkID derives an opaque identifier of a public IP, which is the same across servers and
+// is only meant for equality checks; it does not hide the IP, as the IPv4 space can be
+// enumerated.
+func networkID(ip net.IP) []byte {
+	if ip == nil {
+		return nil
+	}
+
+	h := sha256.Sum256(append([]byte("flare-network:"), ip.To16()...))
+	return h[:16]
+}
+
+func sameIP(a, b net.IP) bool {
+	return a != nil && b != nil && a.Equal(b)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	pb "github.com/vyzo/libp2p-flare-test/pb"
+
+	"github.com/libp2p/go-msgio/protoio"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// EchoServer implements the address echo protocol used by clients to classify the
+// mapping and filtering behavior of their NAT, in the spirit of RFC 5780.
+// The server listens on plain UDP and TCP sockets, which should be bound to specific
+// addresses; with two IPs and two ports per IP, clients can perform the full set of tests.
+type EchoServer struct {
+	udp []net.PacketConn
+	tcp []net.Listener
+}
+
+func NewEchoServer(addrs []string) (*EchoServer, error) {
+	echo := new(EchoServer)
+
+	for _, s := range addrs {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			echo.Close()
+			return nil, fmt.Errorf("error parsing echo address %s: %w", s, err)
+		}
+
+		network, hostport, err := manet.DialArgs(a)
+		if err != nil {
+			echo.Close()
+			return nil, fmt.Errorf("error parsing echo address %s: %w", s, err)
+		}
+
+		switch network {
+		case "udp", "udp4", "udp6":
+			pc, err := net.ListenPacket(network, hostport)
+			if err != nil {
+				echo.Close()
+				return nil, fmt.Errorf("error listening on %s: %w", s, err)
+			}
+			echo.udp = append(echo.udp, pc)
+
+		case "tcp", "tcp4", "tcp6":
+			l, err := net.Listen(network, hostport)
+			if err != nil {
+				echo.Close()
+				return nil, fmt.Errorf("error listening on %s: %w", s, err)
+			}
+			echo.tcp = append(echo.tcp, l)
+
+		default:
+			echo.Close()
+			return nil, fmt.Errorf("unsupported echo address %s", s)
+		}
+	}
+
+	for _, pc := range echo.udp {
+		go echo.serveUDP(pc)
+	}
+	for _, l := range echo.tcp {
+		go echo.serveTCP(l)
+	}
+
+	return echo, nil
+}
+
+func (e *EchoServer) Close() error {
+	for _, pc := range e.udp {
+		pc.Close()
+	}
+	for _, l := range e.tcp {
+		l.Close()
+	}
+	return nil
+}
+
+func (e *EchoServer) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, maxMsgSize)
+	for {
+		n, raddr, err := pc.ReadFrom(buf)
+		if err != nil {
+			log.Warnf("error reading from %s: %s", pc.LocalAddr(), err)
+			return
+		}
+
+		var req pb.EchoRequest
+		if err := req.Unmarshal(buf[:n]); err != nil {
+			log.Debugf("malformed echo request from %s: %s", raddr, err)
+			continue
+		}
+
+		resp, err := echoResponse(&req, raddr)
+		if err != nil {
+			log.Debugf("error constructing echo response for %s: %s", raddr, err)
+			continue
+		}
+
+		from := e.selectUDP(pc, req.GetChangeIP(), req.GetChangePort())
+		if from == nil {
+			log.Debugf("no socket to satisfy change request from %s", raddr)
+			continue
+		}
+
+		data, err := resp.Marshal()
+		if err != nil {
+			log.Warnf("error marshalling echo response: %s", err)
+			continue
+		}
+
+		if _, err := from.WriteTo(data, raddr); err != nil {
+			log.Debugf("error writing echo response to %s: %s", raddr, err)
+		}
+	}
+}
+
+// selectUDP selects the socket to respond from, according to the change request.
+func (e *EchoServer) selectUDP(pc net.PacketConn, changeIP, changePort bool) net.PacketConn {
+	if !changeIP && !changePort {
+		return pc
+	}
+
+	local := pc.LocalAddr().(*net.UDPAddr)
+	for _, other := range e.udp {
+		addr := other.LocalAddr().(*net.UDPAddr)
+		if addr.IP.Equal(local.IP) == changeIP {
+			continue
+		}
+		if (addr.Port == local.Port) == changePort {
+			continue
+		}
+		return other
+	}
+
+	return nil
+}
+
+func (e *EchoServer) serveTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Warnf("error accepting connection on %s: %s", l.Addr(), err)
+			return
+		}
+
+		go e.handleTCP(conn)
+	}
+}
+
+func (e *EchoServer) handleTCP(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	rd := protoio.NewDelimitedReader(conn, maxMsgSize)
+	wr := protoio.NewDelimitedWriter(conn)
+
+	var req pb.EchoRequest
+	if err := rd.ReadMsg(&req); err != nil {
+		log.Debugf("error reading echo request from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+
+	// change requests are not supported for TCP; we just echo the observed address
+	resp, err := echoResponse(&req, conn.RemoteAddr())
+	if err != nil {
+		log.Debugf("error constructing echo response for %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+
+	if err := wr.WriteMsg(resp); err != nil {
+		log.Debugf("error writing echo response to %s: %s", conn.RemoteAddr(), err)
+	}
+}
+
+func echoResponse(req *pb.EchoRequest, raddr net.Addr) (*pb.EchoResponse, error) {
+	observed, err := manet.FromNetAddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.EchoResponse{
+		Nonce:        req.GetNonce(),
+		ObservedAddr: observed.Bytes(),
+	}, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/vyzo/libp2p-flare-test/util"
 
@@ -14,9 +16,9 @@ import (
 	quic "github.com/libp2p/go-libp2p-quic-transport"
 	tls "github.com/libp2p/go-libp2p-tls"
 	tcp "github.com/libp2p/go-tcp-transport"
+	websocket "github.com/libp2p/go-ws-transport"
 
 	logging "github.com/ipfs/go-log"
-	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 )
 
@@ -28,11 +30,24 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "identity":
+			runCommand(util.IdentityCommand)
+			return
+		case "sign-config":
+			runCommand(util.SignConfigCommand)
+			return
+		}
+	}
+
 	idPath := flag.String("-id", "identity", "identity key file path")
 	cfgPath := flag.String("-config", "config.json", "json configuration file")
+	passFile := flag.String("passphraseFile", "", "file containing the identity passphrase")
+	encrypt := flag.Bool("encrypt", false, "encrypt the identity with a passphrase; prompted for unless in a passphrase file or $"+util.PassphraseEnv)
 	flag.Parse()
 
-	privk, err := util.LoadIdentity(*idPath)
+	privk, err := util.LoadIdentity(*idPath, &util.Passphrase{File: *passFile, Encrypt: *encrypt})
 	if err != nil {
 		panic(err)
 	}
@@ -43,6 +58,16 @@ func main() {
 		panic(err)
 	}
 
+	err = util.LoadEnv(ConfigEnvPrefix, &cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		panic(fmt.Errorf("invalid config: %w", err))
+	}
+
 	var opts []libp2p.Option
 
 	opts = append(opts,
@@ -54,20 +79,15 @@ func main() {
 		libp2p.NoTransports,
 		libp2p.Transport(quic.NewTransport),
 		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.Transport(websocket.New),
 	)
 
-	if len(cfg.AnnounceAddrs) > 0 {
-		var addrs []ma.Multiaddr
-		for _, s := range cfg.AnnounceAddrs {
-			a := ma.StringCast(s)
-			addrs = append(addrs, a)
-		}
-		opts = append(opts,
-			libp2p.AddrsFactory(func([]ma.Multiaddr) []ma.Multiaddr {
-				return addrs
-			}),
-		)
+	announce := new(AnnounceAddrs)
+	err = announce.Set(cfg.AnnounceAddrs)
+	if err != nil {
+		panic(err)
 	}
+	opts = append(opts, libp2p.AddrsFactory(announce.Factory))
 
 	ctx := context.Background()
 	host, err := libp2p.New(ctx, opts...)
@@ -75,7 +95,26 @@ func main() {
 		panic(err)
 	}
 
-	_ = NewDaemon(host, &cfg)
+	daemon := NewDaemon(host, &cfg)
+
+	if cfg.ClientConfig != "" {
+		err = daemon.LoadClientConfig(cfg.ClientConfig)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(cfg.EchoAddrs) > 0 {
+		_, err = NewEchoServer(cfg.EchoAddrs)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	go handleReload(*cfgPath, cfg, daemon, announce)
+	go util.WatchRotation(*idPath, host.ID(), util.RotationCheckInterval, func(r *util.Rotation) {
+		log.Warnf("identity rotated to %s; restart before %s, when the running identity retires", r.Current, r.Time.Format(time.RFC3339))
+	})
 
 	fmt.Printf("I am %s\n", host.ID())
 	fmt.Printf("Public Addresses:\n")
@@ -84,6 +123,19 @@ func main() {
 			fmt.Printf("\t%s/p2p/%s\n", addr, host.ID())
 		}
 	}
+	if len(cfg.EchoAddrs) > 0 {
+		fmt.Printf("Echo Addresses:\n")
+		for _, addr := range cfg.EchoAddrs {
+			fmt.Printf("\t%s\n", addr)
+		}
+	}
 
 	select {}
 }
+
+func runCommand(cmd func(prog string, args []string) error) {
+	if err := cmd(os.Args[0], os.Args[2:]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vyzo/libp2p-flare-test/proto"
+	"github.com/vyzo/libp2p-flare-test/util"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// SetSecrets sets the current secret and the previous secrets that are still accepted
+// until they expire.
+func (d *Daemon) SetSecrets(secret string, previous []*PreviousSecret) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.secret = secret
+	d.secrets = previous
+}
+
+// validSecrets returns the secrets we currently accept; the current secret comes first.
+func (d *Daemon) validSecrets() []string {
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now()
+	result := []string{d.secret}
+	for _, ps := range d.secrets {
+		if now.Before(ps.Expires) {
+			result = append(result, ps.Secret)
+		}
+	}
+
+	return result
+}
+
+func verifyProof(secrets []string, salt, nonce, proof []byte) bool {
+	for _, secret := range secrets {
+		if proto.Verify(secret, salt, nonce, proof) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AnnounceAddrs holds the announced addresses of the host, so that they can be changed
+// without restarting it.
+type AnnounceAddrs struct {
+	sync.Mutex
+	addrs []ma.Multiaddr
+}
+
+// Set sets the announced addresses; if empty, the host announces its listen addresses.
+func (a *AnnounceAddrs) Set(addrs []string) error {
+	var result []ma.Multiaddr
+	for _, s := range addrs {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return fmt.Errorf("error parsing announce address %s: %w", s, err)
+		}
+		result = append(result, addr)
+	}
+
+	a.Lock()
+	a.addrs = result
+	a.Unlock()
+
+	return nil
+}
+
+// Factory is the host address factory.
+func (a *AnnounceAddrs) Factory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	a.Lock()
+	defer a.Unlock()
+
+	if len(a.addrs) > 0 {
+		return a.addrs
+	}
+
+	return addrs
+}
+
+// handleReload reloads the configuration on SIGHUP; the secrets, the announced addresses, the
+// client version policy and the client configuration are updated in place, while other changes
+// require a restart.
+func handleReload(cfgPath string, cfg Config, daemon *Daemon, announce *AnnounceAddrs) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	for range ch {
+		log.Infof("reloading configuration")
+
+		next, err := reloadConfig(cfgPath)
+		if err != nil {
+			log.Errorf("error reloading configuration: %s", err)
+			continue
+		}
+
+		if !reflect.DeepEqual(next.ListenAddrs, cfg.ListenAddrs) {
+			log.Warnf("ListenAddrs changed; the change requires a restart")
+		}
+		if !reflect.DeepEqual(next.EchoAddrs, cfg.EchoAddrs) {
+			log.Warnf("EchoAddrs changed; the change requires a restart")
+		}
+
+		if err := announce.Set(next.AnnounceAddrs); err != nil {
+			log.Errorf("error updating announced addresses: %s", err)
+			continue
+		}
+
+		daemon.SetSecrets(next.Secret, next.PreviousSecrets)
+		daemon.SetVersionPolicy(next)
+
+		if next.ClientConfig != "" {
+			if err := daemon.LoadClientConfig(next.ClientConfig); err != nil {
+				log.Errorf("error reloading client config: %s", err)
+			}
+		}
+
+		cfg = *next
+		log.Infof("configuration reloaded")
+	}
+}
+
+func reloadConfig(cfgPath string) (*Config, error) {
+	cfg := new(Config)
+	if err := util.LoadConfig(cfgPath, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := util.LoadEnv(ConfigEnvPrefix, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
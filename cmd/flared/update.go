@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	pb "github.com/vyzo/libp2p-flare-test/pb"
+	"github.com/vyzo/libp2p-flare-test/proto"
+	"github.com/vyzo/libp2p-flare-test/util"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-msgio/protoio"
+)
+
+const configPushTimeout = time.Minute
+
+// LoadClientConfig loads the client configuration to push to clients.
+func (d *Daemon) LoadClientConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading client config: %w", err)
+	}
+
+	return d.SetClientConfig(data)
+}
+
+// SetClientConfig sets the client configuration and pushes it to all authenticated clients
+// with an older configuration. The configuration must be signed, as clients only accept
+// updates signed by the admin key.
+func (d *Daemon) SetClientConfig(data []byte) error {
+	if _, err := util.ParseSignedConfig(data); err != nil {
+		return fmt.Errorf("error loading client config: %w; sign it with sign-config", err)
+	}
+
+	version, err := util.ConfigVersion(data)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	d.clientConfig = data
+	d.configVersion = version
+
+	var stale []peer.ID
+	for p, v := range d.clients {
+		if v < version {
+			stale = append(stale, p)
+		}
+	}
+	d.Unlock()
+
+	log.Infof("client config version is %d", version)
+
+	for _, p := range stale {
+		go d.pushConfig(p)
+	}
+
+	return nil
+}
+
+// authenticated notes that a client has authenticated with the given configuration version
+// and pushes our client configuration if it is newer.
+func (d *Daemon) authenticated(p peer.ID, version uint64) {
+	d.Lock()
+	d.clients[p] = version
+	stale := d.clientConfig != nil && version < d.configVersion
+	d.Unlock()
+
+	if stale {
+		go d.pushConfig(p)
+	}
+}
+
+func (d *Daemon) pushConfig(p peer.ID) {
+	d.Lock()
+	data := d.clientConfig
+	version := d.configVersion
+	d.Unlock()
+
+	log.Debugf("pushing config version %d to %s", version, p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), configPushTimeout)
+	defer cancel()
+
+	s, err := d.host.NewStream(network.WithNoDial(ctx, "config"), p, proto.ConfigProtoID)
+	if err != nil {
+		log.Warnf("error opening config stream to %s: %s", p, err)
+		return
+	}
+
+	s.SetDeadline(time.Now().Add(configPushTimeout))
+
+	wr := protoio.NewDelimitedWriter(s)
+	err = wr.WriteMsg(&pb.ConfigUpdate{Version: &version, Config: data})
+	if err != nil {
+		log.Warnf("error pushing config to %s: %s", p, err)
+		s.Reset()
+		return
+	}
+	s.Close()
+
+	d.Lock()
+	if v, ok := d.clients[p]; ok && v < version {
+		d.clients[p] = version
+	}
+	d.Unlock()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/vyzo/libp2p-flare-test/pb"
+	"github.com/vyzo/libp2p-flare-test/util"
+
+	"github.com/libp2p/go-libp2p-core/network"
+
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+)
+
+const identifyTimeout = 10 * time.Second
+
+// SetVersionPolicy sets the minimum client version and the treatment of outdated clients.
+func (d *Daemon) SetVersionPolicy(cfg *Config) {
+	var minVersion util.Version
+	if cfg.MinClientVersion != "" {
+		// the version has been validated with the configuration
+		minVersion, _ = util.ParseVersion(cfg.MinClientVersion)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.minVersion = minVersion
+	d.upgradeMessage = cfg.UpgradeMessage
+	d.rejectOutdated = cfg.RejectOutdatedClients
+}
+
+// clientVersion returns the version of the client; clients announce their version in the
+// authen message, while older clients only have the agent version reported by identify.
+func (d *Daemon) clientVersion(s network.Stream, version string) string {
+	if version != "" {
+		return version
+	}
+
+	p := s.Conn().RemotePeer()
+	if h, ok := d.host.(interface{ IDService() *identify.IDService }); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), identifyTimeout)
+		defer cancel()
+
+		select {
+		case <-h.IDService().IdentifyWait(s.Conn()):
+		case <-ctx.Done():
+			log.Debugf("timed out waiting for identify with %s", p)
+		}
+	}
+
+	agent, err := d.host.Peerstore().Get(p, "AgentVersion")
+	if err != nil {
+		return ""
+	}
+
+	version, _ = agent.(string)
+	return version
+}
+
+// checkVersion returns an upgrade notice if the client version is below the minimum
+// version; clients with an unknown version are considered outdated.
+func (d *Daemon) checkVersion(version string) *pb.UpgradeNotice {
+	d.Lock()
+	minVersion := d.minVersion
+	message := d.upgradeMessage
+	reject := d.rejectOutdated
+	d.Unlock()
+
+	if minVersion == nil {
+		return nil
+	}
+
+	v, err := util.ParseVersion(version)
+	if err == nil && !v.Less(minVersion) {
+		return nil
+	}
+
+	minVersionStr := minVersion.String()
+	notice := &pb.UpgradeNotice{MinVersion: &minVersionStr}
+	if message != "" {
+		notice.Message = &message
+	}
+	if reject {
+		notice.Rejected = &reject
+	}
+
+	return notice
+}
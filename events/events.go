@@ -0,0 +1,169 @@
+// Package events defines the schema of the events shipped by flarec, shared by the client
+// and the analysis tools.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+)
+
+// SchemaVersion is the current version of the event schema; events that predate schema
+// versioning have version 0 and may lack the IPVersion, Version and Outdated fields.
+const SchemaVersion = 1
+
+// Event is the envelope of all events.
+type Event struct {
+	Schema    int
+	Time      int64 // UNIX time
+	Domain    string
+	IPVersion int
+	Peer      peer.ID
+	Nick      string
+	Type      string
+	Version   string `json:",omitempty"`
+	Outdated  bool   `json:",omitempty"`
+	// Evt is the typed event, as determined by Type; events of unknown type are decoded as
+	// json.RawMessage.
+	Evt interface{}
+}
+
+// Event types
+const (
+	AnnounceEvtT = "announce"
+	ConnectEvtT  = "connect"
+	TraceEvtT    = "trace"
+	DisconnEvtT  = "disconnect"
+	ReserveEvtT  = "reservation"
+)
+
+type AnnounceEvt struct {
+	OSType      string
+	NATType     string
+	NATBehavior *NATBehavior `json:",omitempty"`
+}
+
+// NATBehavior is the classification of the NAT behavior of a peer.
+type NATBehavior struct {
+	Mapping          string
+	Filtering        string
+	PortPreservation bool
+}
+
+type ConnectEvt struct {
+	RemotePeer peer.ID
+	RemoteNick string
+	Network    string // same-lan, same-public-ip or different-networks
+	Success    bool
+	Error      string `json:",omitempty"`
+}
+
+type DisconnEvt struct {
+	RemotePeer peer.ID
+	RemoteNick string
+	Lifetime   int64 // milliseconds
+	IdleTime   int64 // milliseconds
+	Error      string
+}
+
+// ReserveEvt traces the acquisition or loss of a relay reservation
+type ReserveEvt struct {
+	Relay      peer.ID
+	Reserved   bool
+	Expiration int64  `json:",omitempty"` // UNIX time
+	Error      string `json:",omitempty"`
+}
+
+// TraceEvt is a hole punching trace event, as produced by the holepunch service; Evt is the
+// typed sub-event, as determined by Type.
+type TraceEvt holepunch.Event
+
+// UnmarshalJSON decodes an event together with its typed payload.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type envelope Event
+	var raw struct {
+		envelope
+		Evt json.RawMessage
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*e = Event(raw.envelope)
+
+	// unversioned events predate IPv6 domains
+	if e.Schema == 0 && e.IPVersion == 0 {
+		e.IPVersion = 4
+	}
+
+	var evt interface{}
+	switch e.Type {
+	case AnnounceEvtT:
+		evt = new(AnnounceEvt)
+	case ConnectEvtT:
+		evt = new(ConnectEvt)
+	case TraceEvtT:
+		evt = new(TraceEvt)
+	case DisconnEvtT:
+		evt = new(DisconnEvt)
+	case ReserveEvtT:
+		evt = new(ReserveEvt)
+	default:
+		e.Evt = raw.Evt
+		return nil
+	}
+
+	if len(raw.Evt) > 0 && string(raw.Evt) != "null" {
+		if err := json.Unmarshal(raw.Evt, evt); err != nil {
+			return fmt.Errorf("error decoding %s event: %w", e.Type, err)
+		}
+	}
+	e.Evt = evt
+
+	return nil
+}
+
+// UnmarshalJSON decodes a trace event together with its typed sub-event.
+func (e *TraceEvt) UnmarshalJSON(data []byte) error {
+	type envelope TraceEvt
+	var raw struct {
+		envelope
+		Evt json.RawMessage
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*e = TraceEvt(raw.envelope)
+
+	var evt interface{}
+	switch e.Type {
+	case holepunch.DirectDialEvtT:
+		evt = new(holepunch.DirectDialEvt)
+	case holepunch.ProtocolErrorEvtT:
+		evt = new(holepunch.ProtocolErrorEvt)
+	case holepunch.StartHolePunchEvtT:
+		evt = new(holepunch.StartHolePunchEvt)
+	case holepunch.EndHolePunchEvtT:
+		evt = new(holepunch.EndHolePunchEvt)
+	case holepunch.HolePunchAttemptEvtT:
+		evt = new(holepunch.HolePunchAttemptEvt)
+	default:
+		e.Evt = raw.Evt
+		return nil
+	}
+
+	if len(raw.Evt) > 0 && string(raw.Evt) != "null" {
+		if err := json.Unmarshal(raw.Evt, evt); err != nil {
+			return fmt.Errorf("error decoding %s trace event: %w", e.Type, err)
+		}
+	}
+	e.Evt = evt
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+)
+
+const (
+	testPeer   = "QmYyQSo1c1Ym7orWxLYvCrM2EmxFTANf8wXmmE7DWjhx5N"
+	testRemote = "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+)
+
+func TestEventUnmarshal(t *testing.T) {
+	p, err := peer.Decode(testPeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := peer.Decode(testRemote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := func(typ string, evt interface{}) *Event {
+		return &Event{Time: 1600000000, Domain: "TCP", IPVersion: 4, Peer: p, Nick: "alice", Type: typ, Evt: evt}
+	}
+	current := func(typ string, evt interface{}) *Event {
+		return &Event{Schema: 1, Time: 1600000000, Domain: "UDP6", IPVersion: 6, Peer: p, Nick: "alice", Type: typ, Version: "flarec/0.2", Outdated: true, Evt: evt}
+	}
+
+	const legacyEnvelope = `"Time":1600000000,"Domain":"TCP","Peer":"` + testPeer + `","Nick":"alice"`
+	const currentEnvelope = `"Schema":1,"Time":1600000000,"Domain":"UDP6","IPVersion":6,"Peer":"` + testPeer + `","Nick":"alice","Version":"flarec/0.2","Outdated":true`
+
+	cases := []struct {
+		name   string
+		line   string
+		expect *Event
+	}{
+		{
+			name:   "legacy announce",
+			line:   `{` + legacyEnvelope + `,"Type":"announce","Evt":{"OSType":"linux","NATType":"cone"}}`,
+			expect: legacy(AnnounceEvtT, &AnnounceEvt{OSType: "linux", NATType: "cone"}),
+		},
+		{
+			name: "current announce",
+			line: `{` + currentEnvelope + `,"Type":"announce","Evt":{"OSType":"linux","NATType":"cone","NATBehavior":{"Mapping":"endpoint-independent","Filtering":"address-dependent","PortPreservation":true}}}`,
+			expect: current(AnnounceEvtT, &AnnounceEvt{OSType: "linux", NATType: "cone",
+				NATBehavior: &NATBehavior{Mapping: "endpoint-independent", Filtering: "address-dependent", PortPreservation: true}}),
+		},
+		{
+			name:   "legacy connect",
+			line:   `{` + legacyEnvelope + `,"Type":"connect","Evt":{"RemotePeer":"` + testRemote + `","RemoteNick":"bob","Success":false,"Error":"timeout"}}`,
+			expect: legacy(ConnectEvtT, &ConnectEvt{RemotePeer: remote, RemoteNick: "bob", Error: "timeout"}),
+		},
+		{
+			name:   "current connect",
+			line:   `{` + currentEnvelope + `,"Type":"connect","Evt":{"RemotePeer":"` + testRemote + `","RemoteNick":"bob","Network":"different-networks","Success":true}}`,
+			expect: current(ConnectEvtT, &ConnectEvt{RemotePeer: remote, RemoteNick: "bob", Network: "different-networks", Success: true}),
+		},
+		{
+			name: "legacy trace",
+			line: `{` + legacyEnvelope + `,"Type":"trace","Evt":{"Timestamp":5,"Peer":"` + testPeer + `","Remote":"` + testRemote + `","Type":"EndHolePunch","Evt":{"Success":true,"EllapsedTime":1000000}}}`,
+			expect: legacy(TraceEvtT, &TraceEvt{Timestamp: 5, Peer: p, Remote: remote, Type: holepunch.EndHolePunchEvtT,
+				Evt: &holepunch.EndHolePunchEvt{Success: true, EllapsedTime: time.Millisecond}}),
+		},
+		{
+			name: "current trace",
+			line: `{` + currentEnvelope + `,"Type":"trace","Evt":{"Timestamp":5,"Peer":"` + testPeer + `","Remote":"` + testRemote + `","Type":"StartHolePunch","Evt":{"RemoteAddrs":["/ip6/::1/udp/4001/quic"],"RTT":2000000}}}`,
+			expect: current(TraceEvtT, &TraceEvt{Timestamp: 5, Peer: p, Remote: remote, Type: holepunch.StartHolePunchEvtT,
+				Evt: &holepunch.StartHolePunchEvt{RemoteAddrs: []string{"/ip6/::1/udp/4001/quic"}, RTT: 2 * time.Millisecond}}),
+		},
+		{
+			name: "unknown trace",
+			line: `{` + currentEnvelope + `,"Type":"trace","Evt":{"Timestamp":5,"Peer":"` + testPeer + `","Remote":"` + testRemote + `","Type":"Unknown","Evt":{"A":1}}}`,
+			expect: current(TraceEvtT, &TraceEvt{Timestamp: 5, Peer: p, Remote: remote, Type: "Unknown",
+				Evt: json.RawMessage(`{"A":1}`)}),
+		},
+		{
+			name:   "legacy disconnect",
+			line:   `{` + legacyEnvelope + `,"Type":"disconnect","Evt":{"RemotePeer":"` + testRemote + `","RemoteNick":"bob","Lifetime":60000,"IdleTime":30000,"Error":"connection closed"}}`,
+			expect: legacy(DisconnEvtT, &DisconnEvt{RemotePeer: remote, RemoteNick: "bob", Lifetime: 60000, IdleTime: 30000, Error: "connection closed"}),
+		},
+		{
+			name:   "current disconnect",
+			line:   `{` + currentEnvelope + `,"Type":"disconnect","Evt":{"RemotePeer":"` + testRemote + `","RemoteNick":"bob","Lifetime":60000,"IdleTime":30000,"Error":"bad pong"}}`,
+			expect: current(DisconnEvtT, &DisconnEvt{RemotePeer: remote, RemoteNick: "bob", Lifetime: 60000, IdleTime: 30000, Error: "bad pong"}),
+		},
+		{
+			name:   "legacy reservation",
+			line:   `{` + legacyEnvelope + `,"Type":"reservation","Evt":{"Relay":"` + testRemote + `","Reserved":true,"Expiration":1600003600}}`,
+			expect: legacy(ReserveEvtT, &ReserveEvt{Relay: remote, Reserved: true, Expiration: 1600003600}),
+		},
+		{
+			name:   "current reservation",
+			line:   `{` + currentEnvelope + `,"Type":"reservation","Evt":{"Relay":"` + testRemote + `","Reserved":false,"Error":"relay disconnected"}}`,
+			expect: current(ReserveEvtT, &ReserveEvt{Relay: remote, Error: "relay disconnected"}),
+		},
+		{
+			name:   "legacy unknown",
+			line:   `{` + legacyEnvelope + `,"Type":"unknown","Evt":{"A":1}}`,
+			expect: legacy("unknown", json.RawMessage(`{"A":1}`)),
+		},
+		{
+			name:   "current unknown",
+			line:   `{` + currentEnvelope + `,"Type":"unknown","Evt":{"A":1}}`,
+			expect: current("unknown", json.RawMessage(`{"A":1}`)),
+		},
+		{
+			name:   "current IPv4",
+			line:   `{"Schema":1,"Time":1600000000,"Domain":"TCP","IPVersion":0,"Peer":"` + testPeer + `","Nick":"alice","Type":"connect","Evt":null}`,
+			expect: &Event{Schema: 1, Time: 1600000000, Domain: "TCP", Peer: p, Nick: "alice", Type: ConnectEvtT, Evt: &ConnectEvt{}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var evt Event
+			if err := json.Unmarshal([]byte(c.line), &evt); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(&evt, c.expect) {
+				t.Fatalf("expected %+v (%+v), got %+v (%+v)", c.expect, c.expect.Evt, &evt, evt.Evt)
+			}
+		})
+	}
+}
+
+func TestEventUnmarshalMalformed(t *testing.T) {
+	line := `{"Time":1600000000,"Domain":"TCP","Type":"connect","Evt":{"Success":"yes"}}`
+
+	var evt Event
+	if err := json.Unmarshal([]byte(line), &evt); err == nil {
+		t.Fatal("expected error decoding malformed connect event")
+	}
+}
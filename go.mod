@@ -12,9 +12,13 @@ require (
 	github.com/libp2p/go-libp2p-quic-transport v0.10.1-0.20210222105520-71724d9b1a59
 	github.com/libp2p/go-libp2p-tls v0.1.3
 	github.com/libp2p/go-msgio v0.0.6
+	github.com/libp2p/go-reuseport v0.0.2
 	github.com/libp2p/go-tcp-transport v0.2.1
+	github.com/libp2p/go-ws-transport v0.4.0
 	github.com/logzio/logzio-go v0.0.0-20200316143903-ac8fc0e2910e
 	github.com/multiformats/go-multiaddr v0.3.1
+	github.com/syndtr/goleveldb v1.0.0
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
 )
 
 replace github.com/logzio/logzio-go => github.com/Kubuxu/logzio-go v0.0.0-20210225175647-92d2944442ed
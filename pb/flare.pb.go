@@ -171,7 +171,11 @@ func (m *FlareMessage) GetPeerList() *PeerList {
 }
 
 type Authen struct {
-	Nonce                []byte   `protobuf:"bytes,1,req,name=nonce" json:"nonce,omitempty"`
+	Nonce []byte `protobuf:"bytes,1,req,name=nonce" json:"nonce,omitempty"`
+	// the version of the client configuration, so that the server can push updates
+	ConfigVersion *uint64 `protobuf:"varint,2,opt,name=configVersion" json:"configVersion,omitempty"`
+	// the agent version of the client, e.g. flarec/0.2
+	ClientVersion        *string  `protobuf:"bytes,3,opt,name=clientVersion" json:"clientVersion,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -217,13 +221,31 @@ func (m *Authen) GetNonce() []byte {
 	return nil
 }
 
+func (m *Authen) GetConfigVersion() uint64 {
+	if m != nil && m.ConfigVersion != nil {
+		return *m.ConfigVersion
+	}
+	return 0
+}
+
+func (m *Authen) GetClientVersion() string {
+	if m != nil && m.ClientVersion != nil {
+		return *m.ClientVersion
+	}
+	return ""
+}
+
 type Challenge struct {
-	Proof                []byte   `protobuf:"bytes,1,req,name=proof" json:"proof,omitempty"`
-	Salt                 []byte   `protobuf:"bytes,2,req,name=salt" json:"salt,omitempty"`
-	Nonce                []byte   `protobuf:"bytes,3,req,name=nonce" json:"nonce,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Proof []byte `protobuf:"bytes,1,req,name=proof" json:"proof,omitempty"`
+	Salt  []byte `protobuf:"bytes,2,req,name=salt" json:"salt,omitempty"`
+	Nonce []byte `protobuf:"bytes,3,req,name=nonce" json:"nonce,omitempty"`
+	// proofs for previous secrets that are still valid during secret rotation
+	Proofs [][]byte `protobuf:"bytes,4,rep,name=proofs" json:"proofs,omitempty"`
+	// set when the client version is below the minimum version required by the server
+	Upgrade              *UpgradeNotice `protobuf:"bytes,5,opt,name=upgrade" json:"upgrade,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
 }
 
 func (m *Challenge) Reset()         { *m = Challenge{} }
@@ -280,6 +302,84 @@ func (m *Challenge) GetNonce() []byte {
 	return nil
 }
 
+func (m *Challenge) GetProofs() [][]byte {
+	if m != nil {
+		return m.Proofs
+	}
+	return nil
+}
+
+func (m *Challenge) GetUpgrade() *UpgradeNotice {
+	if m != nil {
+		return m.Upgrade
+	}
+	return nil
+}
+
+type UpgradeNotice struct {
+	MinVersion *string `protobuf:"bytes,1,req,name=minVersion" json:"minVersion,omitempty"`
+	Message    *string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	// set when the server refuses service to the client
+	Rejected             *bool    `protobuf:"varint,3,opt,name=rejected" json:"rejected,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UpgradeNotice) Reset()         { *m = UpgradeNotice{} }
+func (m *UpgradeNotice) String() string { return proto.CompactTextString(m) }
+func (*UpgradeNotice) ProtoMessage()    {}
+func (*UpgradeNotice) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4f59e92f58d30fe9, []int{3}
+}
+func (m *UpgradeNotice) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *UpgradeNotice) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_UpgradeNotice.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *UpgradeNotice) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpgradeNotice.Merge(m, src)
+}
+func (m *UpgradeNotice) XXX_Size() int {
+	return m.Size()
+}
+func (m *UpgradeNotice) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpgradeNotice.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpgradeNotice proto.InternalMessageInfo
+
+func (m *UpgradeNotice) GetMinVersion() string {
+	if m != nil && m.MinVersion != nil {
+		return *m.MinVersion
+	}
+	return ""
+}
+
+func (m *UpgradeNotice) GetMessage() string {
+	if m != nil && m.Message != nil {
+		return *m.Message
+	}
+	return ""
+}
+
+func (m *UpgradeNotice) GetRejected() bool {
+	if m != nil && m.Rejected != nil {
+		return *m.Rejected
+	}
+	return false
+}
+
 type Response struct {
 	Proof                []byte   `protobuf:"bytes,1,req,name=proof" json:"proof,omitempty"`
 	Salt                 []byte   `protobuf:"bytes,2,req,name=salt" json:"salt,omitempty"`
@@ -292,7 +392,7 @@ func (m *Response) Reset()         { *m = Response{} }
 func (m *Response) String() string { return proto.CompactTextString(m) }
 func (*Response) ProtoMessage()    {}
 func (*Response) Descriptor() ([]byte, []int) {
-	return fileDescriptor_4f59e92f58d30fe9, []int{3}
+	return fileDescriptor_4f59e92f58d30fe9, []int{4}
 }
 func (m *Response) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -347,7 +447,7 @@ func (m *Announce) Reset()         { *m = Announce{} }
 func (m *Announce) String() string { return proto.CompactTextString(m) }
 func (*Announce) ProtoMessage()    {}
 func (*Announce) Descriptor() ([]byte, []int) {
-	return fileDescriptor_4f59e92f58d30fe9, []int{4}
+	return fileDescriptor_4f59e92f58d30fe9, []int{5}
 }
 func (m *Announce) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -391,9 +491,17 @@ func (m *Announce) GetPeerInfo() *PeerInfo {
 }
 
 type PeerInfo struct {
-	Nick                 *string  `protobuf:"bytes,1,opt,name=nick" json:"nick,omitempty"`
-	PeerID               []byte   `protobuf:"bytes,2,req,name=peerID" json:"peerID,omitempty"`
-	Addrs                [][]byte `protobuf:"bytes,3,rep,name=addrs" json:"addrs,omitempty"`
+	Nick   *string  `protobuf:"bytes,1,opt,name=nick" json:"nick,omitempty"`
+	PeerID []byte   `protobuf:"bytes,2,req,name=peerID" json:"peerID,omitempty"`
+	Addrs  [][]byte `protobuf:"bytes,3,rep,name=addrs" json:"addrs,omitempty"`
+	// set by the server in peer lists when the peer has the same public IP as the requester
+	SamePublicIP *bool `protobuf:"varint,4,opt,name=samePublicIP" json:"samePublicIP,omitempty"`
+	// the NAT device type of the peer, as determined by the peer
+	NatType *string `protobuf:"bytes,5,opt,name=natType" json:"natType,omitempty"`
+	// set by the server in peer lists: an opaque identifier of the public IP of the peer, so that
+	// peers behind the same public IP can be told apart from peers that merely share a nick; it is
+	// an equality token and does not hide the IP
+	NetworkID            []byte   `protobuf:"bytes,6,opt,name=networkID" json:"networkID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -403,7 +511,7 @@ func (m *PeerInfo) Reset()         { *m = PeerInfo{} }
 func (m *PeerInfo) String() string { return proto.CompactTextString(m) }
 func (*PeerInfo) ProtoMessage()    {}
 func (*PeerInfo) Descriptor() ([]byte, []int) {
-	return fileDescriptor_4f59e92f58d30fe9, []int{5}
+	return fileDescriptor_4f59e92f58d30fe9, []int{6}
 }
 func (m *PeerInfo) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -453,6 +561,27 @@ func (m *PeerInfo) GetAddrs() [][]byte {
 	return nil
 }
 
+func (m *PeerInfo) GetSamePublicIP() bool {
+	if m != nil && m.SamePublicIP != nil {
+		return *m.SamePublicIP
+	}
+	return false
+}
+
+func (m *PeerInfo) GetNatType() string {
+	if m != nil && m.NatType != nil {
+		return *m.NatType
+	}
+	return ""
+}
+
+func (m *PeerInfo) GetNetworkID() []byte {
+	if m != nil {
+		return m.NetworkID
+	}
+	return nil
+}
+
 type GetPeers struct {
 	Domain               *string  `protobuf:"bytes,1,req,name=domain" json:"domain,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -464,7 +593,7 @@ func (m *GetPeers) Reset()         { *m = GetPeers{} }
 func (m *GetPeers) String() string { return proto.CompactTextString(m) }
 func (*GetPeers) ProtoMessage()    {}
 func (*GetPeers) Descriptor() ([]byte, []int) {
-	return fileDescriptor_4f59e92f58d30fe9, []int{6}
+	return fileDescriptor_4f59e92f58d30fe9, []int{7}
 }
 func (m *GetPeers) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -511,7 +640,7 @@ func (m *PeerList) Reset()         { *m = PeerList{} }
 func (m *PeerList) String() string { return proto.CompactTextString(m) }
 func (*PeerList) ProtoMessage()    {}
 func (*PeerList) Descriptor() ([]byte, []int) {
-	return fileDescriptor_4f59e92f58d30fe9, []int{7}
+	return fileDescriptor_4f59e92f58d30fe9, []int{8}
 }
 func (m *PeerList) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -547,51 +676,244 @@ func (m *PeerList) GetPeers() []*PeerInfo {
 	return nil
 }
 
+type EchoRequest struct {
+	Nonce                []byte   `protobuf:"bytes,1,req,name=nonce" json:"nonce,omitempty"`
+	ChangeIP             *bool    `protobuf:"varint,2,opt,name=changeIP" json:"changeIP,omitempty"`
+	ChangePort           *bool    `protobuf:"varint,3,opt,name=changePort" json:"changePort,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EchoRequest) Reset()         { *m = EchoRequest{} }
+func (m *EchoRequest) String() string { return proto.CompactTextString(m) }
+func (*EchoRequest) ProtoMessage()    {}
+func (*EchoRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4f59e92f58d30fe9, []int{9}
+}
+func (m *EchoRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *EchoRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_EchoRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *EchoRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EchoRequest.Merge(m, src)
+}
+func (m *EchoRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *EchoRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_EchoRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EchoRequest proto.InternalMessageInfo
+
+func (m *EchoRequest) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+func (m *EchoRequest) GetChangeIP() bool {
+	if m != nil && m.ChangeIP != nil {
+		return *m.ChangeIP
+	}
+	return false
+}
+
+func (m *EchoRequest) GetChangePort() bool {
+	if m != nil && m.ChangePort != nil {
+		return *m.ChangePort
+	}
+	return false
+}
+
+type EchoResponse struct {
+	Nonce                []byte   `protobuf:"bytes,1,req,name=nonce" json:"nonce,omitempty"`
+	ObservedAddr         []byte   `protobuf:"bytes,2,req,name=observedAddr" json:"observedAddr,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EchoResponse) Reset()         { *m = EchoResponse{} }
+func (m *EchoResponse) String() string { return proto.CompactTextString(m) }
+func (*EchoResponse) ProtoMessage()    {}
+func (*EchoResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4f59e92f58d30fe9, []int{10}
+}
+func (m *EchoResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *EchoResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_EchoResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *EchoResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EchoResponse.Merge(m, src)
+}
+func (m *EchoResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *EchoResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_EchoResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EchoResponse proto.InternalMessageInfo
+
+func (m *EchoResponse) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+func (m *EchoResponse) GetObservedAddr() []byte {
+	if m != nil {
+		return m.ObservedAddr
+	}
+	return nil
+}
+
+type ConfigUpdate struct {
+	Version *uint64 `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	// the client configuration, signed by the admin key
+	Config               []byte   `protobuf:"bytes,2,req,name=config" json:"config,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ConfigUpdate) Reset()         { *m = ConfigUpdate{} }
+func (m *ConfigUpdate) String() string { return proto.CompactTextString(m) }
+func (*ConfigUpdate) ProtoMessage()    {}
+func (*ConfigUpdate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4f59e92f58d30fe9, []int{11}
+}
+func (m *ConfigUpdate) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ConfigUpdate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ConfigUpdate.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ConfigUpdate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConfigUpdate.Merge(m, src)
+}
+func (m *ConfigUpdate) XXX_Size() int {
+	return m.Size()
+}
+func (m *ConfigUpdate) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConfigUpdate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConfigUpdate proto.InternalMessageInfo
+
+func (m *ConfigUpdate) GetVersion() uint64 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+func (m *ConfigUpdate) GetConfig() []byte {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("flare.pb.FlareMessage_Type", FlareMessage_Type_name, FlareMessage_Type_value)
 	proto.RegisterType((*FlareMessage)(nil), "flare.pb.FlareMessage")
 	proto.RegisterType((*Authen)(nil), "flare.pb.Authen")
 	proto.RegisterType((*Challenge)(nil), "flare.pb.Challenge")
+	proto.RegisterType((*UpgradeNotice)(nil), "flare.pb.UpgradeNotice")
 	proto.RegisterType((*Response)(nil), "flare.pb.Response")
 	proto.RegisterType((*Announce)(nil), "flare.pb.Announce")
 	proto.RegisterType((*PeerInfo)(nil), "flare.pb.PeerInfo")
 	proto.RegisterType((*GetPeers)(nil), "flare.pb.GetPeers")
 	proto.RegisterType((*PeerList)(nil), "flare.pb.PeerList")
+	proto.RegisterType((*EchoRequest)(nil), "flare.pb.EchoRequest")
+	proto.RegisterType((*EchoResponse)(nil), "flare.pb.EchoResponse")
+	proto.RegisterType((*ConfigUpdate)(nil), "flare.pb.ConfigUpdate")
 }
 
 func init() { proto.RegisterFile("flare.proto", fileDescriptor_4f59e92f58d30fe9) }
 
 var fileDescriptor_4f59e92f58d30fe9 = []byte{
-	// 456 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x92, 0xc1, 0x8e, 0xd3, 0x30,
-	0x10, 0x86, 0x95, 0x26, 0x0d, 0xc9, 0x6c, 0x41, 0x91, 0x41, 0xc8, 0x12, 0x52, 0x15, 0xe5, 0x94,
-	0x53, 0x10, 0x2b, 0x5e, 0x20, 0x14, 0xd3, 0x5d, 0x11, 0xb2, 0x95, 0xdb, 0x3d, 0x70, 0x0c, 0xad,
-	0xdb, 0xad, 0x28, 0x76, 0x94, 0x64, 0x0f, 0xfb, 0x64, 0xbc, 0x02, 0x47, 0x1e, 0x01, 0xf5, 0x49,
-	0xd0, 0xb8, 0x76, 0xb2, 0x2a, 0x5a, 0x89, 0x9b, 0x7f, 0xff, 0xdf, 0x4c, 0xc6, 0xff, 0x04, 0x2e,
-	0xb6, 0x87, 0xaa, 0x11, 0x59, 0xdd, 0xa8, 0x4e, 0x91, 0xc0, 0x88, 0x6f, 0xc9, 0x4f, 0x17, 0x26,
-	0x9f, 0x50, 0x7c, 0x11, 0x6d, 0x5b, 0xed, 0x04, 0x79, 0x0b, 0x5e, 0xf7, 0x50, 0x0b, 0xea, 0xc4,
-	0xa3, 0xf4, 0xc5, 0xe5, 0x9b, 0xcc, 0x92, 0xd9, 0x63, 0x2a, 0x5b, 0x3d, 0xd4, 0x82, 0x6b, 0x90,
-	0xa4, 0xe0, 0x57, 0xf7, 0xdd, 0x9d, 0x90, 0x74, 0x14, 0x3b, 0xe9, 0xc5, 0x65, 0x34, 0x94, 0xe4,
-	0xfa, 0x9e, 0x1b, 0x9f, 0xbc, 0x83, 0x70, 0x7d, 0x57, 0x1d, 0x0e, 0x42, 0xee, 0x04, 0x75, 0x35,
-	0xfc, 0x72, 0x80, 0x67, 0xd6, 0xe2, 0x03, 0x45, 0x32, 0x08, 0x1a, 0xd1, 0xd6, 0x4a, 0xb6, 0x82,
-	0x7a, 0xba, 0x82, 0x0c, 0x15, 0xdc, 0x38, 0xbc, 0x67, 0x90, 0xaf, 0xa4, 0x54, 0xf7, 0x72, 0x2d,
-	0xe8, 0xf8, 0x9c, 0xcf, 0x8d, 0xc3, 0x7b, 0x06, 0xf9, 0x9d, 0xe8, 0x16, 0x42, 0x34, 0x2d, 0xf5,
-	0xcf, 0xf9, 0xb9, 0x71, 0x78, 0xcf, 0x20, 0x5f, 0x0b, 0xd1, 0x14, 0xfb, 0xb6, 0xa3, 0xcf, 0xce,
-	0xf9, 0x85, 0x71, 0x78, 0xcf, 0x24, 0x5f, 0xc1, 0xc3, 0xa8, 0x08, 0x80, 0x9f, 0xdf, 0xae, 0xae,
-	0x58, 0x19, 0x39, 0xe4, 0x39, 0x84, 0xb3, 0xab, 0xbc, 0x28, 0x58, 0x39, 0x67, 0xd1, 0x88, 0x4c,
-	0x20, 0xe0, 0x6c, 0xb9, 0xb8, 0x29, 0x97, 0x2c, 0x72, 0x51, 0xe5, 0x65, 0x79, 0x73, 0x5b, 0xce,
-	0x58, 0xe4, 0xa1, 0x9a, 0xb3, 0xd5, 0x82, 0x31, 0xbe, 0x8c, 0xc6, 0xa8, 0xf0, 0x58, 0x5c, 0x2f,
-	0x57, 0x91, 0x9f, 0x4c, 0xc1, 0x3f, 0xe5, 0x4b, 0x5e, 0xc1, 0x58, 0x2a, 0x7c, 0x31, 0xee, 0x6c,
-	0xc2, 0x4f, 0x22, 0xf9, 0x0c, 0x61, 0x1f, 0x29, 0x22, 0x75, 0xa3, 0xd4, 0xd6, 0x22, 0x5a, 0x10,
-	0x02, 0x5e, 0x5b, 0x1d, 0x3a, 0x3a, 0xd2, 0x97, 0xfa, 0x3c, 0x34, 0x73, 0x1f, 0x37, 0x7b, 0x0f,
-	0x81, 0x4d, 0xfb, 0xff, 0x7b, 0x25, 0x1c, 0x02, 0x9b, 0x39, 0x79, 0x0d, 0xfe, 0x46, 0xfd, 0xa8,
-	0xf6, 0x52, 0x97, 0x85, 0xdc, 0x28, 0x9b, 0xe8, 0xb5, 0xdc, 0x2a, 0x5d, 0xfb, 0x4f, 0xa2, 0xe8,
-	0xf0, 0x9e, 0x49, 0x0a, 0x08, 0xec, 0x2d, 0x7e, 0x53, 0xee, 0xd7, 0xdf, 0xa9, 0x13, 0x3b, 0x69,
-	0xc8, 0xf5, 0x19, 0xbf, 0xa3, 0xd9, 0x8f, 0x66, 0x12, 0xa3, 0x70, 0xea, 0x6a, 0xb3, 0x69, 0x5a,
-	0xea, 0xc6, 0x2e, 0x4e, 0xad, 0x45, 0x92, 0x40, 0x60, 0xb7, 0xfc, 0xd4, 0x84, 0xf8, 0x76, 0xbb,
-	0x59, 0x92, 0xc2, 0xb8, 0xd6, 0x3f, 0x8b, 0x13, 0xbb, 0x4f, 0x8c, 0x7a, 0x02, 0x3e, 0x4c, 0x7e,
-	0x1d, 0xa7, 0xce, 0xef, 0xe3, 0xd4, 0xf9, 0x73, 0x9c, 0x3a, 0x7f, 0x03, 0x00, 0x00, 0xff, 0xff,
-	0x41, 0x51, 0xa2, 0x58, 0x7e, 0x03, 0x00, 0x00,
+	// 702 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x93, 0x51, 0x6e, 0xdb, 0x38,
+	0x10, 0x86, 0x21, 0x5b, 0x76, 0xa4, 0x89, 0xb2, 0x30, 0xb8, 0x8b, 0x5d, 0x21, 0xbb, 0x30, 0x0c,
+	0x62, 0x1f, 0xfc, 0xe4, 0x45, 0x82, 0x3d, 0x40, 0x5d, 0x47, 0x4d, 0x0c, 0xb8, 0x8e, 0x40, 0xdb,
+	0x05, 0xfa, 0x54, 0x28, 0xd2, 0xd8, 0x56, 0x62, 0x93, 0xaa, 0x24, 0xa7, 0xc8, 0x45, 0x7a, 0x86,
+	0xde, 0xa0, 0x57, 0xe8, 0x63, 0x8f, 0x50, 0xe4, 0x24, 0x05, 0x29, 0x51, 0xb2, 0xd3, 0x06, 0xe8,
+	0x1b, 0xff, 0x99, 0x8f, 0xe0, 0xf0, 0x9f, 0x19, 0x38, 0x5e, 0x6e, 0x82, 0x14, 0x07, 0x49, 0x2a,
+	0x72, 0x41, 0xac, 0x52, 0xdc, 0xd0, 0xcf, 0x4d, 0x70, 0x5e, 0x49, 0xf1, 0x1a, 0xb3, 0x2c, 0x58,
+	0x21, 0xf9, 0x0f, 0xcc, 0xfc, 0x21, 0x41, 0xd7, 0xe8, 0x35, 0xfa, 0xbf, 0x9d, 0xff, 0x3d, 0xd0,
+	0xe4, 0x60, 0x9f, 0x1a, 0xcc, 0x1f, 0x12, 0x64, 0x0a, 0x24, 0x7d, 0x68, 0x07, 0xbb, 0x7c, 0x8d,
+	0xdc, 0x6d, 0xf4, 0x8c, 0xfe, 0xf1, 0x79, 0xa7, 0xbe, 0x32, 0x54, 0x71, 0x56, 0xe6, 0xc9, 0x19,
+	0xd8, 0xe1, 0x3a, 0xd8, 0x6c, 0x90, 0xaf, 0xd0, 0x6d, 0x2a, 0xf8, 0xf7, 0x1a, 0x1e, 0xe9, 0x14,
+	0xab, 0x29, 0x32, 0x00, 0x2b, 0xc5, 0x2c, 0x11, 0x3c, 0x43, 0xd7, 0x54, 0x37, 0x48, 0x7d, 0x83,
+	0x95, 0x19, 0x56, 0x31, 0x92, 0x0f, 0x38, 0x17, 0x3b, 0x1e, 0xa2, 0xdb, 0x7a, 0xca, 0x0f, 0xcb,
+	0x0c, 0xab, 0x18, 0xc9, 0xaf, 0x30, 0xf7, 0x11, 0xd3, 0xcc, 0x6d, 0x3f, 0xe5, 0x2f, 0xcb, 0x0c,
+	0xab, 0x18, 0xc9, 0x27, 0x88, 0xe9, 0x24, 0xce, 0x72, 0xf7, 0xe8, 0x29, 0xef, 0x97, 0x19, 0x56,
+	0x31, 0xf4, 0x2d, 0x98, 0xd2, 0x2a, 0x02, 0xd0, 0x1e, 0x2e, 0xe6, 0x57, 0xde, 0xb4, 0x63, 0x90,
+	0x13, 0xb0, 0x47, 0x57, 0xc3, 0xc9, 0xc4, 0x9b, 0x5e, 0x7a, 0x9d, 0x06, 0x71, 0xc0, 0x62, 0xde,
+	0xcc, 0xbf, 0x9e, 0xce, 0xbc, 0x4e, 0x53, 0xaa, 0xe1, 0x74, 0x7a, 0xbd, 0x98, 0x8e, 0xbc, 0x8e,
+	0x29, 0xd5, 0xa5, 0x37, 0xf7, 0x3d, 0x8f, 0xcd, 0x3a, 0x2d, 0xa9, 0xe4, 0x71, 0x32, 0x9e, 0xcd,
+	0x3b, 0x6d, 0x7a, 0x0b, 0xed, 0xc2, 0x5f, 0xf2, 0x07, 0xb4, 0xb8, 0xe0, 0x61, 0xd1, 0x33, 0x87,
+	0x15, 0x82, 0xfc, 0x0b, 0x27, 0xa1, 0xe0, 0xcb, 0x78, 0xf5, 0x06, 0xd3, 0x2c, 0x16, 0x45, 0x7b,
+	0x4c, 0x76, 0x18, 0x54, 0xd4, 0x26, 0x46, 0x9e, 0x6b, 0x4a, 0xf6, 0xc5, 0x66, 0x87, 0x41, 0xfa,
+	0xd1, 0x00, 0xbb, 0xea, 0x8f, 0x7c, 0x2f, 0x49, 0x85, 0x58, 0xea, 0xf7, 0x94, 0x20, 0x04, 0xcc,
+	0x2c, 0xd8, 0xe4, 0x6e, 0x43, 0x05, 0xd5, 0xb9, 0xae, 0xac, 0xb9, 0x5f, 0xd9, 0x9f, 0xd0, 0x56,
+	0x57, 0x32, 0xd7, 0xec, 0x35, 0xfb, 0x0e, 0x2b, 0x15, 0x39, 0x83, 0xa3, 0x5d, 0xb2, 0x4a, 0x83,
+	0x48, 0xf7, 0xee, 0xaf, 0xda, 0xdb, 0x45, 0x91, 0x98, 0x8a, 0x3c, 0x0e, 0x91, 0x69, 0x8e, 0x22,
+	0x9c, 0x1c, 0x64, 0x48, 0x17, 0x60, 0x1b, 0x73, 0xfd, 0x19, 0x59, 0xa0, 0xcd, 0xf6, 0x22, 0xc4,
+	0x85, 0xa3, 0x6d, 0x31, 0xc3, 0xca, 0x0f, 0x9b, 0x69, 0x49, 0x4e, 0xe5, 0xa8, 0xdd, 0x62, 0x98,
+	0x63, 0xa4, 0x4c, 0xb0, 0x58, 0xa5, 0xe9, 0xff, 0x60, 0xe9, 0x61, 0xfb, 0xf5, 0xdf, 0x53, 0x06,
+	0x96, 0x1e, 0x39, 0xf9, 0xe7, 0x48, 0x6c, 0x83, 0x58, 0xd7, 0x54, 0x2a, 0x3d, 0x50, 0x63, 0xbe,
+	0x14, 0xea, 0xee, 0x0f, 0x03, 0x25, 0x33, 0xac, 0x62, 0xe8, 0x27, 0x03, 0x2c, 0x1d, 0x96, 0x8f,
+	0xf2, 0x38, 0xbc, 0x73, 0x0d, 0xf5, 0x13, 0x75, 0x56, 0xe6, 0xca, 0xfc, 0x45, 0x59, 0x4a, 0xa9,
+	0x64, 0xd9, 0x41, 0x14, 0xa5, 0x99, 0xdb, 0x54, 0x9e, 0x17, 0x82, 0x50, 0x70, 0xb2, 0x60, 0x8b,
+	0xfe, 0xee, 0x66, 0x13, 0x87, 0x63, 0x5f, 0xed, 0x98, 0xc5, 0x0e, 0x62, 0xd2, 0x32, 0x1e, 0xe4,
+	0x72, 0x8c, 0x55, 0x5b, 0x6c, 0xa6, 0x25, 0xf9, 0x07, 0x6c, 0x8e, 0xf9, 0x07, 0x91, 0xde, 0x8d,
+	0x2f, 0xd4, 0xfa, 0x38, 0xac, 0x0e, 0x50, 0x0a, 0x96, 0xde, 0xa0, 0xe7, 0xbe, 0x2f, 0x8d, 0xd5,
+	0x5b, 0x43, 0xfa, 0xd0, 0x4a, 0xd4, 0x22, 0x1a, 0xbd, 0xe6, 0x33, 0x3e, 0x14, 0x00, 0x7d, 0x07,
+	0xc7, 0x5e, 0xb8, 0x16, 0x0c, 0xdf, 0xef, 0x30, 0xcb, 0x9f, 0x99, 0xff, 0x53, 0xb0, 0xc2, 0x75,
+	0xc0, 0x57, 0x38, 0xf6, 0x55, 0xab, 0x2d, 0x56, 0x69, 0x39, 0x25, 0xc5, 0xd9, 0x17, 0x69, 0x5e,
+	0x76, 0x7b, 0x2f, 0x42, 0xaf, 0xc0, 0x29, 0x1e, 0xa8, 0x7b, 0xfe, 0x93, 0x17, 0x28, 0x38, 0xe2,
+	0x26, 0xc3, 0xf4, 0x1e, 0xa3, 0x61, 0x14, 0xa5, 0xa5, 0xe1, 0x07, 0x31, 0xfa, 0x02, 0x9c, 0x91,
+	0x5a, 0xb8, 0x45, 0x12, 0x05, 0x39, 0x4a, 0x33, 0xef, 0xf7, 0x86, 0xd3, 0x64, 0x5a, 0x4a, 0x8b,
+	0x8a, 0xd5, 0xd4, 0x8d, 0x2b, 0xd4, 0x4b, 0xe7, 0xcb, 0x63, 0xd7, 0xf8, 0xfa, 0xd8, 0x35, 0xbe,
+	0x3d, 0x76, 0x8d, 0xef, 0x03, 0x00, 0xc8, 0x4f, 0xac, 0x71, 0xc7, 0x05, 0x00, 0x00,
 }
 
 func (m *FlareMessage) Marshal() (dAtA []byte, err error) {
@@ -724,6 +1046,18 @@ func (m *Authen) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.ClientVersion != nil {
+		i -= len(*m.ClientVersion)
+		copy(dAtA[i:], *m.ClientVersion)
+		i = encodeVarintFlare(dAtA, i, uint64(len(*m.ClientVersion)))
+		i--
+		dAtA[i] = 0x1a
+	}
+	if m.ConfigVersion != nil {
+		i = encodeVarintFlare(dAtA, i, uint64(*m.ConfigVersion))
+		i--
+		dAtA[i] = 0x10
+	}
 	if m.Nonce == nil {
 		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("nonce")
 	} else {
@@ -760,6 +1094,27 @@ func (m *Challenge) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.Upgrade != nil {
+		{
+			size, err := m.Upgrade.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintFlare(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x2a
+	}
+	if len(m.Proofs) > 0 {
+		for iNdEx := len(m.Proofs) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Proofs[iNdEx])
+			copy(dAtA[i:], m.Proofs[iNdEx])
+			i = encodeVarintFlare(dAtA, i, uint64(len(m.Proofs[iNdEx])))
+			i--
+			dAtA[i] = 0x22
+		}
+	}
 	if m.Nonce == nil {
 		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("nonce")
 	} else {
@@ -790,6 +1145,59 @@ func (m *Challenge) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *UpgradeNotice) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *UpgradeNotice) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *UpgradeNotice) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Rejected != nil {
+		i--
+		if *m.Rejected {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.Message != nil {
+		i -= len(*m.Message)
+		copy(dAtA[i:], *m.Message)
+		i = encodeVarintFlare(dAtA, i, uint64(len(*m.Message)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if m.MinVersion == nil {
+		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("minVersion")
+	} else {
+		i -= len(*m.MinVersion)
+		copy(dAtA[i:], *m.MinVersion)
+		i = encodeVarintFlare(dAtA, i, uint64(len(*m.MinVersion)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func (m *Response) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -909,6 +1317,30 @@ func (m *PeerInfo) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.NetworkID != nil {
+		i -= len(m.NetworkID)
+		copy(dAtA[i:], m.NetworkID)
+		i = encodeVarintFlare(dAtA, i, uint64(len(m.NetworkID)))
+		i--
+		dAtA[i] = 0x32
+	}
+	if m.NatType != nil {
+		i -= len(*m.NatType)
+		copy(dAtA[i:], *m.NatType)
+		i = encodeVarintFlare(dAtA, i, uint64(len(*m.NatType)))
+		i--
+		dAtA[i] = 0x2a
+	}
+	if m.SamePublicIP != nil {
+		i--
+		if *m.SamePublicIP {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x20
+	}
 	if len(m.Addrs) > 0 {
 		for iNdEx := len(m.Addrs) - 1; iNdEx >= 0; iNdEx-- {
 			i -= len(m.Addrs[iNdEx])
@@ -1014,14 +1446,158 @@ func (m *PeerList) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
-func encodeVarintFlare(dAtA []byte, offset int, v uint64) int {
-	offset -= sovFlare(v)
-	base := offset
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
-	}
+func (m *EchoRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *EchoRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *EchoRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.ChangePort != nil {
+		i--
+		if *m.ChangePort {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.ChangeIP != nil {
+		i--
+		if *m.ChangeIP {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.Nonce == nil {
+		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("nonce")
+	} else {
+		i -= len(m.Nonce)
+		copy(dAtA[i:], m.Nonce)
+		i = encodeVarintFlare(dAtA, i, uint64(len(m.Nonce)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *EchoResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *EchoResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *EchoResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.ObservedAddr == nil {
+		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("observedAddr")
+	} else {
+		i -= len(m.ObservedAddr)
+		copy(dAtA[i:], m.ObservedAddr)
+		i = encodeVarintFlare(dAtA, i, uint64(len(m.ObservedAddr)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if m.Nonce == nil {
+		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("nonce")
+	} else {
+		i -= len(m.Nonce)
+		copy(dAtA[i:], m.Nonce)
+		i = encodeVarintFlare(dAtA, i, uint64(len(m.Nonce)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *ConfigUpdate) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ConfigUpdate) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ConfigUpdate) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Config == nil {
+		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("config")
+	} else {
+		i -= len(m.Config)
+		copy(dAtA[i:], m.Config)
+		i = encodeVarintFlare(dAtA, i, uint64(len(m.Config)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if m.Version == nil {
+		return 0, github_com_gogo_protobuf_proto.NewRequiredNotSetError("version")
+	} else {
+		i = encodeVarintFlare(dAtA, i, uint64(*m.Version))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintFlare(dAtA []byte, offset int, v uint64) int {
+	offset -= sovFlare(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
 	dAtA[offset] = uint8(v)
 	return base
 }
@@ -1074,6 +1650,13 @@ func (m *Authen) Size() (n int) {
 		l = len(m.Nonce)
 		n += 1 + l + sovFlare(uint64(l))
 	}
+	if m.ConfigVersion != nil {
+		n += 1 + sovFlare(uint64(*m.ConfigVersion))
+	}
+	if m.ClientVersion != nil {
+		l = len(*m.ClientVersion)
+		n += 1 + l + sovFlare(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1098,6 +1681,39 @@ func (m *Challenge) Size() (n int) {
 		l = len(m.Nonce)
 		n += 1 + l + sovFlare(uint64(l))
 	}
+	if len(m.Proofs) > 0 {
+		for _, b := range m.Proofs {
+			l = len(b)
+			n += 1 + l + sovFlare(uint64(l))
+		}
+	}
+	if m.Upgrade != nil {
+		l = m.Upgrade.Size()
+		n += 1 + l + sovFlare(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *UpgradeNotice) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.MinVersion != nil {
+		l = len(*m.MinVersion)
+		n += 1 + l + sovFlare(uint64(l))
+	}
+	if m.Message != nil {
+		l = len(*m.Message)
+		n += 1 + l + sovFlare(uint64(l))
+	}
+	if m.Rejected != nil {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1164,6 +1780,17 @@ func (m *PeerInfo) Size() (n int) {
 			n += 1 + l + sovFlare(uint64(l))
 		}
 	}
+	if m.SamePublicIP != nil {
+		n += 2
+	}
+	if m.NatType != nil {
+		l = len(*m.NatType)
+		n += 1 + l + sovFlare(uint64(l))
+	}
+	if m.NetworkID != nil {
+		l = len(m.NetworkID)
+		n += 1 + l + sovFlare(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1204,6 +1831,67 @@ func (m *PeerList) Size() (n int) {
 	return n
 }
 
+func (m *EchoRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Nonce != nil {
+		l = len(m.Nonce)
+		n += 1 + l + sovFlare(uint64(l))
+	}
+	if m.ChangeIP != nil {
+		n += 2
+	}
+	if m.ChangePort != nil {
+		n += 2
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *EchoResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Nonce != nil {
+		l = len(m.Nonce)
+		n += 1 + l + sovFlare(uint64(l))
+	}
+	if m.ObservedAddr != nil {
+		l = len(m.ObservedAddr)
+		n += 1 + l + sovFlare(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *ConfigUpdate) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Version != nil {
+		n += 1 + sovFlare(uint64(*m.Version))
+	}
+	if m.Config != nil {
+		l = len(m.Config)
+		n += 1 + l + sovFlare(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
 func sovFlare(x uint64) (n int) {
 	return (math_bits.Len64(x|1) + 6) / 7
 }
@@ -1483,10 +2171,7 @@ func (m *FlareMessage) Unmarshal(dAtA []byte) error {
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if (iNdEx + skippy) < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if (iNdEx + skippy) > l {
@@ -1570,16 +2255,66 @@ func (m *Authen) Unmarshal(dAtA []byte) error {
 			}
 			iNdEx = postIndex
 			hasFields[0] |= uint64(0x00000001)
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ConfigVersion", wireType)
+			}
+			var v uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.ConfigVersion = &v
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ClientVersion", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(dAtA[iNdEx:postIndex])
+			m.ClientVersion = &s
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFlare(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if (iNdEx + skippy) < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if (iNdEx + skippy) > l {
@@ -1733,73 +2468,9 @@ func (m *Challenge) Unmarshal(dAtA []byte) error {
 			}
 			iNdEx = postIndex
 			hasFields[0] |= uint64(0x00000004)
-		default:
-			iNdEx = preIndex
-			skippy, err := skipFlare(dAtA[iNdEx:])
-			if err != nil {
-				return err
-			}
-			if skippy < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if (iNdEx + skippy) < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if (iNdEx + skippy) > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
-			iNdEx += skippy
-		}
-	}
-	if hasFields[0]&uint64(0x00000001) == 0 {
-		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("proof")
-	}
-	if hasFields[0]&uint64(0x00000002) == 0 {
-		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("salt")
-	}
-	if hasFields[0]&uint64(0x00000004) == 0 {
-		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("nonce")
-	}
-
-	if iNdEx > l {
-		return io.ErrUnexpectedEOF
-	}
-	return nil
-}
-func (m *Response) Unmarshal(dAtA []byte) error {
-	var hasFields [1]uint64
-	l := len(dAtA)
-	iNdEx := 0
-	for iNdEx < l {
-		preIndex := iNdEx
-		var wire uint64
-		for shift := uint(0); ; shift += 7 {
-			if shift >= 64 {
-				return ErrIntOverflowFlare
-			}
-			if iNdEx >= l {
-				return io.ErrUnexpectedEOF
-			}
-			b := dAtA[iNdEx]
-			iNdEx++
-			wire |= uint64(b&0x7F) << shift
-			if b < 0x80 {
-				break
-			}
-		}
-		fieldNum := int32(wire >> 3)
-		wireType := int(wire & 0x7)
-		if wireType == 4 {
-			return fmt.Errorf("proto: Response: wiretype end group for non-group")
-		}
-		if fieldNum <= 0 {
-			return fmt.Errorf("proto: Response: illegal tag %d (wire type %d)", fieldNum, wire)
-		}
-		switch fieldNum {
-		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Proof", wireType)
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Proofs", wireType)
 			}
 			var byteLen int
 			for shift := uint(0); ; shift += 7 {
@@ -1826,17 +2497,14 @@ func (m *Response) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Proof = append(m.Proof[:0], dAtA[iNdEx:postIndex]...)
-			if m.Proof == nil {
-				m.Proof = []byte{}
-			}
+			m.Proofs = append(m.Proofs, make([]byte, postIndex-iNdEx))
+			copy(m.Proofs[len(m.Proofs)-1], dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-			hasFields[0] |= uint64(0x00000001)
-		case 2:
+		case 5:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Salt", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Upgrade", wireType)
 			}
-			var byteLen int
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowFlare
@@ -1846,37 +2514,35 @@ func (m *Response) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				byteLen |= int(b&0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if byteLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthFlare
 			}
-			postIndex := iNdEx + byteLen
+			postIndex := iNdEx + msglen
 			if postIndex < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Salt = append(m.Salt[:0], dAtA[iNdEx:postIndex]...)
-			if m.Salt == nil {
-				m.Salt = []byte{}
+			if m.Upgrade == nil {
+				m.Upgrade = &UpgradeNotice{}
+			}
+			if err := m.Upgrade.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
 			}
 			iNdEx = postIndex
-			hasFields[0] |= uint64(0x00000002)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFlare(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if (iNdEx + skippy) < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if (iNdEx + skippy) > l {
@@ -1892,13 +2558,16 @@ func (m *Response) Unmarshal(dAtA []byte) error {
 	if hasFields[0]&uint64(0x00000002) == 0 {
 		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("salt")
 	}
+	if hasFields[0]&uint64(0x00000004) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("nonce")
+	}
 
 	if iNdEx > l {
 		return io.ErrUnexpectedEOF
 	}
 	return nil
 }
-func (m *Announce) Unmarshal(dAtA []byte) error {
+func (m *UpgradeNotice) Unmarshal(dAtA []byte) error {
 	var hasFields [1]uint64
 	l := len(dAtA)
 	iNdEx := 0
@@ -1922,15 +2591,15 @@ func (m *Announce) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: Announce: wiretype end group for non-group")
+			return fmt.Errorf("proto: UpgradeNotice: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: Announce: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: UpgradeNotice: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Domain", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field MinVersion", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -1959,14 +2628,14 @@ func (m *Announce) Unmarshal(dAtA []byte) error {
 				return io.ErrUnexpectedEOF
 			}
 			s := string(dAtA[iNdEx:postIndex])
-			m.Domain = &s
+			m.MinVersion = &s
 			iNdEx = postIndex
 			hasFields[0] |= uint64(0x00000001)
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field PeerInfo", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Message", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowFlare
@@ -1976,39 +2645,53 @@ func (m *Announce) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthFlare
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.PeerInfo == nil {
-				m.PeerInfo = &PeerInfo{}
+			s := string(dAtA[iNdEx:postIndex])
+			m.Message = &s
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Rejected", wireType)
 			}
-			if err := m.PeerInfo.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
 			}
-			iNdEx = postIndex
-			hasFields[0] |= uint64(0x00000002)
+			b := bool(v != 0)
+			m.Rejected = &b
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFlare(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if (iNdEx + skippy) < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if (iNdEx + skippy) > l {
@@ -2019,10 +2702,7 @@ func (m *Announce) Unmarshal(dAtA []byte) error {
 		}
 	}
 	if hasFields[0]&uint64(0x00000001) == 0 {
-		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("domain")
-	}
-	if hasFields[0]&uint64(0x00000002) == 0 {
-		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("peerInfo")
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("minVersion")
 	}
 
 	if iNdEx > l {
@@ -2030,7 +2710,7 @@ func (m *Announce) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *PeerInfo) Unmarshal(dAtA []byte) error {
+func (m *Response) Unmarshal(dAtA []byte) error {
 	var hasFields [1]uint64
 	l := len(dAtA)
 	iNdEx := 0
@@ -2054,48 +2734,15 @@ func (m *PeerInfo) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PeerInfo: wiretype end group for non-group")
+			return fmt.Errorf("proto: Response: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PeerInfo: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Response: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Nick", wireType)
-			}
-			var stringLen uint64
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowFlare
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthFlare
-			}
-			postIndex := iNdEx + intStringLen
-			if postIndex < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			s := string(dAtA[iNdEx:postIndex])
-			m.Nick = &s
-			iNdEx = postIndex
-		case 2:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field PeerID", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Proof", wireType)
 			}
 			var byteLen int
 			for shift := uint(0); ; shift += 7 {
@@ -2122,15 +2769,15 @@ func (m *PeerInfo) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.PeerID = append(m.PeerID[:0], dAtA[iNdEx:postIndex]...)
-			if m.PeerID == nil {
-				m.PeerID = []byte{}
+			m.Proof = append(m.Proof[:0], dAtA[iNdEx:postIndex]...)
+			if m.Proof == nil {
+				m.Proof = []byte{}
 			}
 			iNdEx = postIndex
 			hasFields[0] |= uint64(0x00000001)
-		case 3:
+		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Addrs", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Salt", wireType)
 			}
 			var byteLen int
 			for shift := uint(0); ; shift += 7 {
@@ -2157,19 +2804,19 @@ func (m *PeerInfo) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Addrs = append(m.Addrs, make([]byte, postIndex-iNdEx))
-			copy(m.Addrs[len(m.Addrs)-1], dAtA[iNdEx:postIndex])
+			m.Salt = append(m.Salt[:0], dAtA[iNdEx:postIndex]...)
+			if m.Salt == nil {
+				m.Salt = []byte{}
+			}
 			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000002)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFlare(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if (iNdEx + skippy) < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if (iNdEx + skippy) > l {
@@ -2180,7 +2827,10 @@ func (m *PeerInfo) Unmarshal(dAtA []byte) error {
 		}
 	}
 	if hasFields[0]&uint64(0x00000001) == 0 {
-		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("peerID")
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("proof")
+	}
+	if hasFields[0]&uint64(0x00000002) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("salt")
 	}
 
 	if iNdEx > l {
@@ -2188,7 +2838,7 @@ func (m *PeerInfo) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *GetPeers) Unmarshal(dAtA []byte) error {
+func (m *Announce) Unmarshal(dAtA []byte) error {
 	var hasFields [1]uint64
 	l := len(dAtA)
 	iNdEx := 0
@@ -2212,10 +2862,10 @@ func (m *GetPeers) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: GetPeers: wiretype end group for non-group")
+			return fmt.Errorf("proto: Announce: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: GetPeers: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Announce: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
@@ -2252,16 +2902,50 @@ func (m *GetPeers) Unmarshal(dAtA []byte) error {
 			m.Domain = &s
 			iNdEx = postIndex
 			hasFields[0] |= uint64(0x00000001)
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PeerInfo", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.PeerInfo == nil {
+				m.PeerInfo = &PeerInfo{}
+			}
+			if err := m.PeerInfo.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000002)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFlare(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
-				return ErrInvalidLengthFlare
-			}
-			if (iNdEx + skippy) < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if (iNdEx + skippy) > l {
@@ -2274,13 +2958,17 @@ func (m *GetPeers) Unmarshal(dAtA []byte) error {
 	if hasFields[0]&uint64(0x00000001) == 0 {
 		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("domain")
 	}
+	if hasFields[0]&uint64(0x00000002) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("peerInfo")
+	}
 
 	if iNdEx > l {
 		return io.ErrUnexpectedEOF
 	}
 	return nil
 }
-func (m *PeerList) Unmarshal(dAtA []byte) error {
+func (m *PeerInfo) Unmarshal(dAtA []byte) error {
+	var hasFields [1]uint64
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -2303,17 +2991,17 @@ func (m *PeerList) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PeerList: wiretype end group for non-group")
+			return fmt.Errorf("proto: PeerInfo: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PeerList: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: PeerInfo: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Peers", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Nick", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowFlare
@@ -2323,36 +3011,732 @@ func (m *PeerList) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
-				return ErrInvalidLengthFlare
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(dAtA[iNdEx:postIndex])
+			m.Nick = &s
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PeerID", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PeerID = append(m.PeerID[:0], dAtA[iNdEx:postIndex]...)
+			if m.PeerID == nil {
+				m.PeerID = []byte{}
+			}
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000001)
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Addrs", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Addrs = append(m.Addrs, make([]byte, postIndex-iNdEx))
+			copy(m.Addrs[len(m.Addrs)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SamePublicIP", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			b := bool(v != 0)
+			m.SamePublicIP = &b
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NatType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(dAtA[iNdEx:postIndex])
+			m.NatType = &s
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NetworkID", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.NetworkID = append(m.NetworkID[:0], dAtA[iNdEx:postIndex]...)
+			if m.NetworkID == nil {
+				m.NetworkID = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipFlare(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+	if hasFields[0]&uint64(0x00000001) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("peerID")
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *GetPeers) Unmarshal(dAtA []byte) error {
+	var hasFields [1]uint64
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowFlare
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: GetPeers: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: GetPeers: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Domain", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(dAtA[iNdEx:postIndex])
+			m.Domain = &s
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000001)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipFlare(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+	if hasFields[0]&uint64(0x00000001) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("domain")
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PeerList) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowFlare
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PeerList: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PeerList: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Peers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Peers = append(m.Peers, &PeerInfo{})
+			if err := m.Peers[len(m.Peers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipFlare(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *EchoRequest) Unmarshal(dAtA []byte) error {
+	var hasFields [1]uint64
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowFlare
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: EchoRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: EchoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Nonce", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
 			}
-			postIndex := iNdEx + msglen
+			m.Nonce = append(m.Nonce[:0], dAtA[iNdEx:postIndex]...)
+			if m.Nonce == nil {
+				m.Nonce = []byte{}
+			}
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000001)
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ChangeIP", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			b := bool(v != 0)
+			m.ChangeIP = &b
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ChangePort", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			b := bool(v != 0)
+			m.ChangePort = &b
+		default:
+			iNdEx = preIndex
+			skippy, err := skipFlare(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+	if hasFields[0]&uint64(0x00000001) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("nonce")
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *EchoResponse) Unmarshal(dAtA []byte) error {
+	var hasFields [1]uint64
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowFlare
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: EchoResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: EchoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Nonce", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + byteLen
 			if postIndex < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Peers = append(m.Peers, &PeerInfo{})
-			if err := m.Peers[len(m.Peers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
+			m.Nonce = append(m.Nonce[:0], dAtA[iNdEx:postIndex]...)
+			if m.Nonce == nil {
+				m.Nonce = []byte{}
+			}
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000001)
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ObservedAddr", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ObservedAddr = append(m.ObservedAddr[:0], dAtA[iNdEx:postIndex]...)
+			if m.ObservedAddr == nil {
+				m.ObservedAddr = []byte{}
 			}
 			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000002)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFlare(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthFlare
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+	if hasFields[0]&uint64(0x00000001) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("nonce")
+	}
+	if hasFields[0]&uint64(0x00000002) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("observedAddr")
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ConfigUpdate) Unmarshal(dAtA []byte) error {
+	var hasFields [1]uint64
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowFlare
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ConfigUpdate: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ConfigUpdate: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			var v uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Version = &v
+			hasFields[0] |= uint64(0x00000001)
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Config", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFlare
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthFlare
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
 				return ErrInvalidLengthFlare
 			}
-			if (iNdEx + skippy) < 0 {
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Config = append(m.Config[:0], dAtA[iNdEx:postIndex]...)
+			if m.Config == nil {
+				m.Config = []byte{}
+			}
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000002)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipFlare(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthFlare
 			}
 			if (iNdEx + skippy) > l {
@@ -2362,6 +3746,12 @@ func (m *PeerList) Unmarshal(dAtA []byte) error {
 			iNdEx += skippy
 		}
 	}
+	if hasFields[0]&uint64(0x00000001) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("version")
+	}
+	if hasFields[0]&uint64(0x00000002) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("config")
+	}
 
 	if iNdEx > l {
 		return io.ErrUnexpectedEOF
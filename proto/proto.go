@@ -9,6 +9,10 @@ import (
 
 const ProtoID = "/libp2p/flare-test/presence"
 
+const MonitorProtoID = "/libp2p/flare-test/monitor"
+
+const ConfigProtoID = "/libp2p/flare-test/config"
+
 func Proof(secret string, salt, nonce []byte) []byte {
 	secretBytes := []byte(secret)
 	blob := make([]byte, len(secretBytes)+len(nonce)+len(salt))
@@ -4,6 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 func LoadConfig(cfgPath string, cfg interface{}) error {
@@ -14,5 +23,202 @@ func LoadConfig(cfgPath string, cfg interface{}) error {
 	defer cfgFile.Close()
 
 	decoder := json.NewDecoder(cfgFile)
-	return decoder.Decode(cfg)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("error parsing %s: %w", cfgPath, err)
+	}
+
+	return nil
+}
+
+// LoadEnv overrides configuration fields from the environment; the variable for each field is
+// the prefix followed by the field name in upper snake case, e.g. FLAREC_SERVER_ADDR_TCP for
+// ServerAddrTCP with prefix FLAREC. String lists can be given as comma separated values; all other
+// non string fields are given in their json representation.
+//
+// The fields of nested structs, and of the structs in lists, can also be overridden individually,
+// with the field path as the variable name, e.g. FLAREC_SCHEDULE_QUIET_HOURS for Schedule.QuietHours
+// or FLAREC_DOMAINS_0_RELAY_ADDR for Domains[0].RelayAddr. Lists are extended by setting fields of the
+// next index. Individual fields are applied after the variable of the whole struct or list, if any.
+func LoadEnv(prefix string, cfg interface{}) error {
+	return loadEnvStruct(prefix, reflect.ValueOf(cfg).Elem())
+}
+
+func loadEnvStruct(prefix string, v reflect.Value) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := prefix + "_" + envName(field.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setField(v.Field(i), value); err != nil {
+				return fmt.Errorf("error parsing %s: %w", name, err)
+			}
+		}
+
+		if err := loadEnvNested(name, v.Field(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadEnvNested overrides the fields of a nested struct, or of the structs in a list, from the
+// variables prefixed by the name of the field.
+func loadEnvNested(prefix string, fv reflect.Value) error {
+	if !hasEnvPrefix(prefix + "_") {
+		return nil
+	}
+
+	ft := fv.Type()
+	switch {
+	case isEnvStruct(ft):
+		return loadEnvStruct(prefix, fv)
+
+	case ft.Kind() == reflect.Ptr && isEnvStruct(ft.Elem()):
+		if fv.IsNil() {
+			fv.Set(reflect.New(ft.Elem()))
+		}
+		return loadEnvStruct(prefix, fv.Elem())
+
+	case ft.Kind() == reflect.Slice && (isEnvStruct(ft.Elem()) || (ft.Elem().Kind() == reflect.Ptr && isEnvStruct(ft.Elem().Elem()))):
+		for i := 0; ; i++ {
+			name := fmt.Sprintf("%s_%d", prefix, i)
+			if i >= fv.Len() {
+				if !hasEnvPrefix(name + "_") {
+					break
+				}
+				fv.Set(reflect.Append(fv, reflect.Zero(ft.Elem())))
+			}
+
+			if err := loadEnvNested(name, fv.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// isEnvStruct returns true for structs whose fields are set individually from the environment;
+// structs with their own json representation, like time.Time, are set as a whole.
+func isEnvStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(jsonUnmarshalerType)
+}
+
+func hasEnvPrefix(prefix string) bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setField(fv reflect.Value, value string) error {
+	switch {
+	case fv.Kind() == reflect.String:
+		fv.SetString(value)
+		return nil
+
+	case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(value, "["):
+		var list []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		fv.Set(reflect.ValueOf(list).Convert(fv.Type()))
+		return nil
+
+	default:
+		ptr := fv.Addr().Interface()
+		err := json.Unmarshal([]byte(value), ptr)
+		if err != nil {
+			// accept unquoted strings for types represented as json strings, eg durations
+			if err2 := json.Unmarshal([]byte(strconv.Quote(value)), ptr); err2 == nil {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// envName converts a field name to upper snake case, keeping acronyms together;
+// eg ServerAddrTCP becomes SERVER_ADDR_TCP.
+func envName(field string) string {
+	runes := []rune(field)
+
+	var sb strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteRune('_')
+			}
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+
+	return sb.String()
+}
+
+// CheckAddr checks that s is a valid multiaddr, including a peer ID if needPeer is set,
+// returning an error that names the offending configuration field.
+func CheckAddr(field, s string, needPeer bool) error {
+	if s == "" {
+		return fmt.Errorf("%s: empty address", field)
+	}
+
+	a, err := ma.NewMultiaddr(s)
+	if err != nil {
+		return fmt.Errorf("%s: invalid multiaddr %q: %w", field, s, err)
+	}
+
+	if needPeer {
+		if _, err := peer.AddrInfoFromP2pAddr(a); err != nil {
+			return fmt.Errorf("%s: address %q must end with /p2p/<peer ID>: %w", field, s, err)
+		}
+	}
+
+	return nil
+}
+
+// Duration is a time.Duration that is represented in json configuration as a
+// duration string, eg "30m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("error parsing duration: %w", err)
+	}
+
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("error parsing duration: %w", err)
+	}
+
+	*d = Duration(dur)
+	return nil
+}
+
+// Or returns the duration, or dflt if the duration is unset.
+func (d Duration) Or(dflt time.Duration) time.Duration {
+	if d == 0 {
+		return dflt
+	}
+	return time.Duration(d)
 }
@@ -0,0 +1,149 @@
+package util
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testSchedule struct {
+	QuietHours string
+	Until      time.Time
+	Backoff    *Duration
+}
+
+type testDomain struct {
+	Name      string
+	RelayAddr string
+	Timeout   Duration
+}
+
+type testConfig struct {
+	ServerAddrTCP string
+	ConnHighWater int
+	MaxBackoff    Duration
+	Bootstrappers []string
+	Schedule      testSchedule
+	Limits        *testSchedule
+	Domains       []*testDomain
+	Overrides     []testDomain
+	Since         time.Time
+	private       string
+}
+
+// setenv sets an environment variable for the duration of the test.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]string{
+		"ServerAddrTCP":  "SERVER_ADDR_TCP",
+		"ConnHighWater":  "CONN_HIGH_WATER",
+		"IPVersion":      "IP_VERSION",
+		"RelayAddr":      "RELAY_ADDR",
+		"ServerAddrUDP6": "SERVER_ADDR_UDP6",
+		"Name":           "NAME",
+	}
+
+	for field, expect := range cases {
+		if name := envName(field); name != expect {
+			t.Errorf("envName(%q): expected %s, got %s", field, expect, name)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	since := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	setenv(t, "TEST_SERVER_ADDR_TCP", "/ip4/1.2.3.4/tcp/4001")
+	setenv(t, "TEST_CONN_HIGH_WATER", "100")
+	setenv(t, "TEST_MAX_BACKOFF", "30m")
+	setenv(t, "TEST_BOOTSTRAPPERS", "/ip4/1.1.1.1/tcp/1, /ip4/2.2.2.2/tcp/2")
+	setenv(t, "TEST_SCHEDULE_QUIET_HOURS", "22:00-06:00")
+	setenv(t, "TEST_SCHEDULE_UNTIL", since.Format(time.RFC3339))
+	setenv(t, "TEST_SCHEDULE_BACKOFF", "1h")
+	setenv(t, "TEST_LIMITS_QUIET_HOURS", "01:00-02:00")
+	setenv(t, "TEST_DOMAINS_0_RELAY_ADDR", "/ip4/3.3.3.3/tcp/3")
+	setenv(t, "TEST_DOMAINS_1_NAME", "WS")
+	setenv(t, "TEST_DOMAINS_1_TIMEOUT", "2m")
+	setenv(t, "TEST_OVERRIDES", `[{"Name":"A"}]`)
+	setenv(t, "TEST_OVERRIDES_0_RELAY_ADDR", "/ip4/4.4.4.4/tcp/4")
+	setenv(t, "TEST_SINCE", `"`+since.Format(time.RFC3339)+`"`)
+	setenv(t, "TEST_PRIVATE", "ignored")
+
+	cfg := testConfig{
+		ServerAddrTCP: "/ip4/127.0.0.1/tcp/4001",
+		Domains:       []*testDomain{{Name: "TCP", RelayAddr: "/ip4/127.0.0.1/tcp/4002"}},
+	}
+	if err := LoadEnv("TEST", &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	backoff := Duration(time.Hour)
+	expect := testConfig{
+		ServerAddrTCP: "/ip4/1.2.3.4/tcp/4001",
+		ConnHighWater: 100,
+		MaxBackoff:    Duration(30 * time.Minute),
+		Bootstrappers: []string{"/ip4/1.1.1.1/tcp/1", "/ip4/2.2.2.2/tcp/2"},
+		Schedule:      testSchedule{QuietHours: "22:00-06:00", Until: since, Backoff: &backoff},
+		Limits:        &testSchedule{QuietHours: "01:00-02:00"},
+		Domains: []*testDomain{
+			{Name: "TCP", RelayAddr: "/ip4/3.3.3.3/tcp/3"},
+			{Name: "WS", Timeout: Duration(2 * time.Minute)},
+		},
+		Overrides: []testDomain{{Name: "A", RelayAddr: "/ip4/4.4.4.4/tcp/4"}},
+		Since:     since,
+	}
+
+	if !reflect.DeepEqual(cfg, expect) {
+		t.Fatalf("expected %+v, got %+v", expect, cfg)
+	}
+}
+
+func TestLoadEnvUnset(t *testing.T) {
+	cfg := testConfig{ServerAddrTCP: "/ip4/127.0.0.1/tcp/4001"}
+	if err := LoadEnv("TEST_UNSET", &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := testConfig{ServerAddrTCP: "/ip4/127.0.0.1/tcp/4001"}
+	if !reflect.DeepEqual(cfg, expect) {
+		t.Fatalf("expected %+v, got %+v", expect, cfg)
+	}
+}
+
+func TestLoadEnvError(t *testing.T) {
+	cases := map[string]string{
+		"TEST_ERR_CONN_HIGH_WATER":     "many",
+		"TEST_ERR_MAX_BACKOFF":         "soon",
+		"TEST_ERR_DOMAINS_0_TIMEOUT":   "1 fortnight",
+		"TEST_ERR_SCHEDULE_UNTIL":      "yesterday",
+		"TEST_ERR_LIMITS_BACKOFF":      "-",
+		"TEST_ERR_OVERRIDES_0_TIMEOUT": "x",
+	}
+
+	for key, value := range cases {
+		t.Run(key, func(t *testing.T) {
+			setenv(t, key, value)
+
+			var cfg testConfig
+			if err := LoadEnv("TEST_ERR", &cfg); err == nil {
+				t.Fatalf("expected error parsing %s=%s", key, value)
+			}
+		})
+	}
+}
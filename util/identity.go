@@ -1,50 +1,397 @@
 package util
 
 import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedIdentityMagic prefixes encrypted identity files, distinguishing them from
+// plaintext marshalled keys.
+var encryptedIdentityMagic = []byte("flare-identity:")
+
+// RotationCheckInterval is the interval at which running programs check for identity rotations.
+const RotationCheckInterval = time.Hour
+
+const (
+	identityKDF     = "scrypt"
+	identitySaltLen = 32
 )
 
-func LoadIdentity(idPath string) (crypto.PrivKey, error) {
+// encryptedIdentity is the envelope of an encrypted identity file; the key is derived from
+// the passphrase with scrypt and the marshalled private key is sealed with XChaCha20-Poly1305.
+type encryptedIdentity struct {
+	Version    int
+	KDF        string
+	N, R, P    int
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// LoadIdentity loads the identity in idPath, generating it if it doesn't exist.
+// If pass is enabled, then new identities are encrypted and existing plaintext identities
+// are encrypted in place; encrypted identities always need a passphrase from pass.
+func LoadIdentity(idPath string, pass *Passphrase) (crypto.PrivKey, error) {
+	if err := recoverRotation(idPath); err != nil {
+		return nil, fmt.Errorf("error rotating identity %s: %w", idPath, err)
+	}
+
 	if _, err := os.Stat(idPath); err == nil {
-		return readIdentity(idPath)
+		return readIdentity(idPath, pass)
 	} else if os.IsNotExist(err) {
 		fmt.Printf("Generating peer identity in %s\n", idPath)
-		return generatePersistentIdentity(idPath)
+		return generatePersistentIdentity(idPath, pass)
 	} else {
 		return nil, err
 	}
 }
 
-func readIdentity(path string) (crypto.PrivKey, error) {
-	bytes, err := ioutil.ReadFile(path)
+func readIdentity(path string, pass *Passphrase) (crypto.PrivKey, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return crypto.UnmarshalPrivateKey(bytes)
-}
+	if bytes.HasPrefix(data, encryptedIdentityMagic) {
+		if pass == nil {
+			return nil, fmt.Errorf("identity %s is encrypted, but no passphrase source is available", path)
+		}
 
-func generatePersistentIdentity(path string) (crypto.PrivKey, error) {
-	privk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+		passphrase, err := pass.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = decryptIdentity(data, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting identity %s: %w", path, err)
+		}
+
+		return crypto.UnmarshalPrivateKey(data)
+	}
+
+	privk, err := crypto.UnmarshalPrivateKey(data)
 	if err != nil {
 		return nil, err
 	}
 
-	bytes, err := crypto.MarshalPrivateKey(privk)
+	if pass.Enabled() {
+		fmt.Printf("Encrypting peer identity in %s\n", path)
+		if err := WriteIdentity(path, privk, pass); err != nil {
+			return nil, fmt.Errorf("error encrypting identity %s: %w", path, err)
+		}
+	}
+
+	return privk, nil
+}
+
+func generatePersistentIdentity(path string, pass *Passphrase) (crypto.PrivKey, error) {
+	privk, err := GenerateIdentity()
 	if err != nil {
 		return nil, err
 	}
 
-	err = ioutil.WriteFile(path, bytes, 0400)
+	err = WriteIdentity(path, privk, pass)
 
 	return privk, err
 }
 
+// WriteIdentity (over)writes the identity in path, encrypting it if pass is enabled.
+// The file is replaced atomically, so that an identity is never lost to a partial write.
+func WriteIdentity(path string, privk crypto.PrivKey, pass *Passphrase) error {
+	data, err := crypto.MarshalPrivateKey(privk)
+	if err != nil {
+		return err
+	}
+
+	if pass.Enabled() {
+		passphrase, err := pass.Get()
+		if err != nil {
+			return err
+		}
+
+		data, err = encryptIdentity(data, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := ioutil.WriteFile(tmp, data, 0400); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
 func GenerateIdentity() (crypto.PrivKey, error) {
 	privk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
 	return privk, err
 }
+
+// KeyTypes are the supported identity key types, by name.
+var KeyTypes = map[string]int{
+	"ed25519":   crypto.Ed25519,
+	"secp256k1": crypto.Secp256k1,
+	"ecdsa":     crypto.ECDSA,
+	"rsa":       crypto.RSA,
+}
+
+// GenerateIdentityOfType generates an identity with the named key type; bits is only used
+// for RSA keys.
+func GenerateIdentityOfType(keyType string, bits int) (crypto.PrivKey, error) {
+	typ, ok := KeyTypes[strings.ToLower(keyType)]
+	if !ok {
+		return nil, fmt.Errorf("unknown key type %s", keyType)
+	}
+
+	privk, _, err := crypto.GenerateKeyPair(typ, bits)
+	return privk, err
+}
+
+// IsEncryptedIdentity returns whether the identity in path is encrypted.
+func IsEncryptedIdentity(path string) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.HasPrefix(data, encryptedIdentityMagic), nil
+}
+
+// Rotation is an identity rotation: the next identity takes over immediately, while the
+// previous identity is kept in <path>.old until it retires at Time. This gives operators a grace
+// period to distribute the next peer ID and restart running programs, which keep using the
+// identity they were started with.
+type Rotation struct {
+	Time     time.Time
+	Previous peer.ID
+	Current  peer.ID
+}
+
+// RotateIdentity replaces the identity in path with privk, keeping the previous identity
+// until the grace period ends.
+func RotateIdentity(path string, privk crypto.PrivKey, grace time.Duration, pass *Passphrase) error {
+	prev, err := ReadIdentityFile(path, pass)
+	if err != nil {
+		return err
+	}
+
+	r := &Rotation{Time: time.Now().Add(grace)}
+	if r.Previous, err = peer.IDFromPrivateKey(prev); err != nil {
+		return fmt.Errorf("error extracting peer ID: %w", err)
+	}
+	if r.Current, err = peer.IDFromPrivateKey(privk); err != nil {
+		return fmt.Errorf("error extracting peer ID: %w", err)
+	}
+
+	// the next identity is written before the rotation record, which commits the rotation: an
+	// interrupted rotation is completed or discarded by the next LoadIdentity; see recoverRotation.
+	// A previous rotation is superseded, so that its record is not mistaken for this one.
+	if err := os.Remove(path + ".rotation"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := WriteIdentity(path+".next", privk, pass); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".rotation.tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path+".rotation"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return switchIdentity(path)
+}
+
+// switchIdentity moves the identity in path to path.old and the next identity in its place.
+func switchIdentity(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(path + ".old")
+		if err := os.Rename(path, path+".old"); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(path+".next", path)
+}
+
+// PendingRotation returns the rotation of the identity in path, if the previous identity
+// has not retired yet.
+func PendingRotation(path string) (*Rotation, error) {
+	data, err := ioutil.ReadFile(path + ".rotation")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var r Rotation
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error parsing rotation: %w", err)
+	}
+
+	return &r, nil
+}
+
+// recoverRotation completes interrupted rotations of the identity in path, retires the
+// previous identity once the grace period is over, and cleans up orphaned rotation files.
+func recoverRotation(path string) error {
+	if _, err := os.Stat(path + ".next"); err == nil {
+		if _, err := os.Stat(path + ".rotation"); os.IsNotExist(err) {
+			// the rotation was interrupted before it was recorded
+			fmt.Printf("Removing incomplete identity rotation in %s\n", path)
+			if err := os.Remove(path + ".next"); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("Completing interrupted identity rotation in %s\n", path)
+			if err := switchIdentity(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	r, err := PendingRotation(path)
+	if err != nil {
+		fmt.Printf("Removing unreadable identity rotation in %s: %s\n", path, err)
+		return os.Remove(path + ".rotation")
+	}
+	if r == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(path + ".old"); os.IsNotExist(err) {
+		fmt.Printf("Removing orphaned identity rotation in %s\n", path)
+		return os.Remove(path + ".rotation")
+	}
+
+	if time.Now().Before(r.Time) {
+		return nil
+	}
+
+	fmt.Printf("Retiring previous peer identity %s in %s\n", r.Previous, path)
+	if err := os.Remove(path + ".old"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(path + ".rotation")
+}
+
+// WatchRotation periodically checks whether the identity in path has been rotated away from
+// the running identity id, calling notify once when it has; it retires the previous identity
+// when the grace period is over. It never returns.
+func WatchRotation(path string, id peer.ID, interval time.Duration, notify func(r *Rotation)) {
+	notified := false
+	for {
+		time.Sleep(interval)
+
+		r, err := PendingRotation(path)
+		if err != nil || r == nil {
+			continue
+		}
+
+		if !notified && r.Previous == id {
+			notify(r)
+			notified = true
+		}
+
+		if !time.Now().Before(r.Time) {
+			if err := recoverRotation(path); err != nil {
+				fmt.Printf("error retiring previous identity in %s: %s\n", path, err)
+			}
+		}
+	}
+}
+
+func encryptIdentity(data, passphrase []byte) ([]byte, error) {
+	env := encryptedIdentity{
+		Version: 1,
+		KDF:     identityKDF,
+		N:       1 << 15,
+		R:       8,
+		P:       1,
+		Salt:    make([]byte, identitySaltLen),
+		Nonce:   make([]byte, chacha20poly1305.NonceSizeX),
+	}
+
+	if _, err := rand.Read(env.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return nil, err
+	}
+
+	aead, err := env.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	env.Ciphertext = aead.Seal(nil, env.Nonce, data, encryptedIdentityMagic)
+
+	result, err := json.Marshal(&env)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, encryptedIdentityMagic...), result...), nil
+}
+
+func decryptIdentity(data, passphrase []byte) ([]byte, error) {
+	var env encryptedIdentity
+	if err := json.Unmarshal(data[len(encryptedIdentityMagic):], &env); err != nil {
+		return nil, fmt.Errorf("error parsing encrypted identity: %w", err)
+	}
+
+	if env.Version != 1 || env.KDF != identityKDF {
+		return nil, fmt.Errorf("unsupported encrypted identity version %d (%s)", env.Version, env.KDF)
+	}
+
+	aead, err := env.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := aead.Open(nil, env.Nonce, env.Ciphertext, encryptedIdentityMagic)
+	if err != nil {
+		return nil, fmt.Errorf("bad passphrase or corrupted identity")
+	}
+
+	return result, nil
+}
+
+func (env *encryptedIdentity) cipher(passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, env.Salt, env.N, env.R, env.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving identity key: %w", err)
+	}
+
+	return chacha20poly1305.NewX(key)
+}
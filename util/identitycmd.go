@@ -0,0 +1,207 @@
+package util
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const identityUsage = `usage: %s identity <command> [options] <path>
+
+commands:
+  show      show the peer ID and key type of an identity, and any pending rotation
+  generate  generate a new identity
+  rotate    generate a new identity, keeping the previous one for a grace period
+  export    export the public key of an identity
+`
+
+// IdentityCommand implements the identity management subcommand of the flare programs.
+func IdentityCommand(prog string, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, identityUsage, prog)
+		return fmt.Errorf("missing identity command")
+	}
+
+	cmd := args[0]
+	fs := flag.NewFlagSet(prog+" identity "+cmd, flag.ExitOnError)
+	passFile := fs.String("passphraseFile", "", "file containing the identity passphrase")
+	encrypt := fs.Bool("encrypt", false, "encrypt the identity with a passphrase")
+
+	var keyType *string
+	var bits *int
+	var grace *time.Duration
+	var out *string
+
+	switch cmd {
+	case "show":
+	case "generate", "rotate":
+		keyType = fs.String("type", "ed25519", "key type: ed25519, secp256k1, ecdsa or rsa")
+		bits = fs.Int("bits", 2048, "key size for RSA keys")
+		if cmd == "rotate" {
+			grace = fs.Duration("grace", 24*time.Hour, "grace period during which the previous identity is kept")
+		}
+	case "export":
+		out = fs.String("out", "", "write the raw marshalled public key to a file instead of printing it in base64")
+	default:
+		fmt.Fprintf(os.Stderr, identityUsage, prog)
+		return fmt.Errorf("unknown identity command %s", cmd)
+	}
+
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("missing identity path")
+	}
+
+	path := fs.Arg(0)
+	pass := &Passphrase{File: *passFile, Encrypt: *encrypt}
+
+	if err := recoverRotation(path); err != nil {
+		return fmt.Errorf("error recovering identity rotation %s: %w", path, err)
+	}
+
+	switch cmd {
+	case "show":
+		return showIdentity(path, pass)
+	case "generate":
+		return generateIdentityCmd(path, *keyType, *bits, pass)
+	case "rotate":
+		return rotateIdentityCmd(path, *keyType, *bits, *grace, pass)
+	default:
+		return exportIdentity(path, *out, pass)
+	}
+}
+
+func showIdentity(path string, pass *Passphrase) error {
+	privk, err := ReadIdentityFile(path, pass)
+	if err != nil {
+		return err
+	}
+
+	if err := printIdentity("Peer ID", privk); err != nil {
+		return err
+	}
+
+	encrypted, err := IsEncryptedIdentity(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Encrypted: %t\n", encrypted)
+
+	r, err := PendingRotation(path)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+
+	fmt.Printf("Previous peer ID: %s (retires %s)\n", r.Previous, r.Time.Format(time.RFC3339))
+	return nil
+}
+
+func generateIdentityCmd(path, keyType string, bits int, pass *Passphrase) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("identity %s already exists; use rotate to replace it", path)
+	}
+
+	privk, err := GenerateIdentityOfType(keyType, bits)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteIdentity(path, privk, pass); err != nil {
+		return err
+	}
+
+	return printIdentity("Peer ID", privk)
+}
+
+func rotateIdentityCmd(path, keyType string, bits int, grace time.Duration, pass *Passphrase) error {
+	// the next identity is protected like the current one
+	encrypted, err := IsEncryptedIdentity(path)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		pass.Encrypt = true
+	}
+
+	current, err := ReadIdentityFile(path, pass)
+	if err != nil {
+		return err
+	}
+
+	next, err := GenerateIdentityOfType(keyType, bits)
+	if err != nil {
+		return err
+	}
+
+	if err := RotateIdentity(path, next, grace, pass); err != nil {
+		return err
+	}
+
+	if err := printIdentity("Peer ID", next); err != nil {
+		return err
+	}
+	if err := printIdentity("Previous peer ID", current); err != nil {
+		return err
+	}
+	fmt.Printf("Previous identity retires: %s\n", time.Now().Add(grace).Format(time.RFC3339))
+	fmt.Println("Restart running programs to switch to the new identity.")
+
+	return nil
+}
+
+func exportIdentity(path, out string, pass *Passphrase) error {
+	privk, err := ReadIdentityFile(path, pass)
+	if err != nil {
+		return err
+	}
+
+	data, err := crypto.MarshalPublicKey(privk.GetPublic())
+	if err != nil {
+		return err
+	}
+
+	if out != "" {
+		return ioutil.WriteFile(out, data, 0644)
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(data))
+	return nil
+}
+
+// ReadIdentityFile reads an existing identity, without generating, migrating or rotating it.
+func ReadIdentityFile(path string, pass *Passphrase) (crypto.PrivKey, error) {
+	encrypted, err := IsEncryptedIdentity(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !encrypted {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.UnmarshalPrivateKey(data)
+	}
+
+	return readIdentity(path, pass)
+}
+
+func printIdentity(label string, privk crypto.PrivKey) error {
+	id, err := peer.IDFromPrivateKey(privk)
+	if err != nil {
+		return fmt.Errorf("error extracting peer ID: %w", err)
+	}
+
+	fmt.Printf("%s: %s (%s)\n", label, id, privk.Type())
+	return nil
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PassphraseEnv is the environment variable holding the identity passphrase.
+const PassphraseEnv = "FLARE_IDENTITY_PASSPHRASE"
+
+// Passphrase is the source of the passphrase protecting identity files; the passphrase is
+// taken, in order of precedence, from File, the PassphraseEnv environment variable, or an
+// interactive prompt. The passphrase is obtained once and reused for all identities.
+type Passphrase struct {
+	// File is the path of a file containing the passphrase
+	File string
+	// Encrypt requests encryption of identities even when the passphrase is not provided
+	// by File or the environment, in which case it is prompted for.
+	Encrypt bool
+
+	once       sync.Once
+	passphrase []byte
+	err        error
+}
+
+// Enabled returns whether identities should be encrypted.
+func (p *Passphrase) Enabled() bool {
+	if p == nil {
+		return false
+	}
+
+	return p.Encrypt || p.File != "" || os.Getenv(PassphraseEnv) != ""
+}
+
+// Get returns the passphrase.
+func (p *Passphrase) Get() ([]byte, error) {
+	p.once.Do(func() {
+		p.passphrase, p.err = p.read()
+	})
+
+	return p.passphrase, p.err
+}
+
+func (p *Passphrase) read() ([]byte, error) {
+	if p.File != "" {
+		data, err := ioutil.ReadFile(p.File)
+		if err != nil {
+			return nil, fmt.Errorf("error reading passphrase file: %w", err)
+		}
+
+		passphrase := bytes.TrimRight(data, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("empty passphrase in %s", p.File)
+		}
+
+		return passphrase, nil
+	}
+
+	if env := os.Getenv(PassphraseEnv); env != "" {
+		return []byte(env), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("no passphrase: set %s or use a passphrase file", PassphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, "Identity passphrase: ")
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("error reading passphrase: %w", err)
+	}
+
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+
+	return passphrase, nil
+}
@@ -0,0 +1,234 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// configSignaturePrefix is prepended to the configuration when signing, so that admin
+// signatures cannot be confused with signatures over other data.
+var configSignaturePrefix = []byte("flare-config:")
+
+// ErrUnsignedConfig is returned when a configuration is not signed, but an admin key is pinned.
+var ErrUnsignedConfig = errors.New("configuration is not signed")
+
+// SignedConfig is the envelope of a configuration signed by the test administrator.
+type SignedConfig struct {
+	Config    json.RawMessage
+	Signature []byte
+}
+
+// SignConfig signs the json configuration in data with the admin key, returning the signed
+// configuration envelope.
+func SignConfig(privk crypto.PrivKey, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, fmt.Errorf("error parsing configuration: %w", err)
+	}
+
+	sc := SignedConfig{Config: buf.Bytes()}
+
+	sig, err := privk.Sign(append(append([]byte{}, configSignaturePrefix...), sc.Config...))
+	if err != nil {
+		return nil, fmt.Errorf("error signing configuration: %w", err)
+	}
+	sc.Signature = sig
+
+	return json.MarshalIndent(&sc, "", "  ")
+}
+
+// ParseSignedConfig parses a signed configuration envelope, without verifying the signature;
+// it returns ErrUnsignedConfig if data is not a signed configuration.
+func ParseSignedConfig(data []byte) (*SignedConfig, error) {
+	var sc SignedConfig
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&sc); err != nil || len(sc.Config) == 0 || len(sc.Signature) == 0 {
+		return nil, ErrUnsignedConfig
+	}
+
+	return &sc, nil
+}
+
+// OpenSignedConfig verifies a signed configuration envelope against the admin key and
+// returns the configuration. If data is not a signed configuration, then it is returned as
+// is together with ErrUnsignedConfig.
+func OpenSignedConfig(data []byte, adminKey crypto.PubKey) ([]byte, error) {
+	sc, err := ParseSignedConfig(data)
+	if err != nil {
+		return data, err
+	}
+
+	// the signature is over the compact configuration, so that it survives reformatting
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, sc.Config); err != nil {
+		return nil, fmt.Errorf("error parsing signed configuration: %w", err)
+	}
+
+	ok, err := adminKey.Verify(append(append([]byte{}, configSignaturePrefix...), buf.Bytes()...), sc.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying configuration signature: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("bad configuration signature")
+	}
+
+	return sc.Config, nil
+}
+
+// LoadSignedConfig loads a configuration, which must be signed by the admin key if one is
+// pinned. Without a pinned admin key the configuration is loaded without verification and
+// verified is false.
+func LoadSignedConfig(cfgPath string, adminKey crypto.PubKey, cfg interface{}) (verified bool, err error) {
+	data, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return false, fmt.Errorf("error opening %s: %w", cfgPath, err)
+	}
+
+	if adminKey != nil {
+		data, err = OpenSignedConfig(data, adminKey)
+		if err != nil {
+			return false, fmt.Errorf("error verifying %s: %w", cfgPath, err)
+		}
+		verified = true
+	} else {
+		var sc SignedConfig
+		if err := json.Unmarshal(data, &sc); err == nil && len(sc.Config) > 0 {
+			data = sc.Config
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return false, fmt.Errorf("error parsing %s: %w", cfgPath, err)
+	}
+
+	return verified, nil
+}
+
+// LoadEnvSigned applies environment overrides to a configuration like LoadEnv. Overrides of a
+// signed configuration are refused, as they would bypass the admin signature; variables that
+// repeat the signed values are allowed.
+func LoadEnvSigned(prefix string, cfg interface{}, signed bool) error {
+	if !signed {
+		return LoadEnv(prefix, cfg)
+	}
+
+	before, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := LoadEnv(prefix, cfg); err != nil {
+		return err
+	}
+
+	after, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(before, after) {
+		var vars []string
+		for _, kv := range os.Environ() {
+			if strings.HasPrefix(kv, prefix+"_") {
+				vars = append(vars, kv[:strings.Index(kv, "=")])
+			}
+		}
+		return fmt.Errorf("environment overrides the signed configuration (%s)", strings.Join(vars, ", "))
+	}
+
+	return nil
+}
+
+// ConfigVersion returns the ConfigVersion field of a, possibly signed, configuration.
+func ConfigVersion(data []byte) (uint64, error) {
+	var sc SignedConfig
+	if err := json.Unmarshal(data, &sc); err == nil && len(sc.Config) > 0 {
+		data = sc.Config
+	}
+
+	var cfg struct {
+		ConfigVersion uint64
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return 0, fmt.Errorf("error parsing configuration: %w", err)
+	}
+
+	return cfg.ConfigVersion, nil
+}
+
+// ParseAdminKey parses an admin public key, given either in base64 or as the path of a file
+// containing the marshalled key, as exported by the identity export command.
+func ParseAdminKey(s string) (crypto.PubKey, error) {
+	var data []byte
+	if _, err := os.Stat(s); err == nil {
+		data, err = ioutil.ReadFile(s)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding admin key: %w", err)
+		}
+	}
+
+	return crypto.UnmarshalPublicKey(data)
+}
+
+const signConfigUsage = `usage: %s sign-config [options] <config>
+
+Signs a client configuration with the admin key.
+`
+
+// SignConfigCommand implements the configuration signing subcommand.
+func SignConfigCommand(prog string, args []string) error {
+	fs := flag.NewFlagSet(prog+" sign-config", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, signConfigUsage, prog)
+		fs.PrintDefaults()
+	}
+	keyPath := fs.String("key", "admin-identity", "admin identity key file path")
+	out := fs.String("out", "", "signed configuration output path; defaults to stdout")
+	passFile := fs.String("passphraseFile", "", "file containing the admin identity passphrase")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("missing configuration path")
+	}
+
+	privk, err := ReadIdentityFile(*keyPath, &Passphrase{File: *passFile})
+	if err != nil {
+		return fmt.Errorf("error loading admin key: %w", err)
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	signed, err := SignConfig(privk, data)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		fmt.Println(string(signed))
+		return nil
+	}
+
+	return ioutil.WriteFile(*out, signed, 0644)
+}
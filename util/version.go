@@ -0,0 +1,64 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a dotted numeric version, as found in agent versions like flarec/0.2.
+type Version []int
+
+// ParseVersion parses a version, with an optional agent name prefix; pre-release and build
+// suffixes are ignored.
+func ParseVersion(s string) (Version, error) {
+	str := s
+	if i := strings.LastIndexByte(str, '/'); i >= 0 {
+		str = str[i+1:]
+	}
+	str = strings.TrimPrefix(str, "v")
+	if i := strings.IndexAny(str, "-+ "); i >= 0 {
+		str = str[:i]
+	}
+
+	if str == "" {
+		return nil, fmt.Errorf("malformed version %q", s)
+	}
+
+	var result Version
+	for _, part := range strings.Split(str, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("malformed version %q", s)
+		}
+		result = append(result, n)
+	}
+
+	return result, nil
+}
+
+// Less returns true if v is an earlier version than other; missing components count as 0.
+func (v Version) Less(other Version) bool {
+	for i := 0; i < len(v) || i < len(other); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(other) {
+			b = other[i]
+		}
+		if a != b {
+			return a < b
+		}
+	}
+
+	return false
+}
+
+func (v Version) String() string {
+	parts := make([]string, len(v))
+	for i, n := range v {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}